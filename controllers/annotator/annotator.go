@@ -1,39 +1,55 @@
 package annotator
 
 import (
+	"context"
+
 	"github.com/sirupsen/logrus"
 
 	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
-	client "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
 	clusterclient "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
 	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/downstream"
+	"github.com/rancher/kubecon2018/pkg/metrics"
+	"github.com/rancher/kubecon2018/util"
+	"k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	kubernetesVersionAnnotation = "clusterprovisioner.rke.io/kubernetes-version"
+	reasonVersionChanged        = "VersionChanged"
 )
 
 type Controller struct {
+	ctx             context.Context
 	clusterInformer cache.SharedIndexInformer
+	synced          []cache.InformerSynced
 	clusterClient   clusterclient.Interface
+	clients         *downstream.Manager
+	recorder        record.EventRecorder
 }
 
-func Register(kubeconfigClient clusterclient.Interface,
-	sampleInformerFactory informers.SharedInformerFactory) {
+func Register(ctx context.Context, kubeconfigClient clusterclient.Interface,
+	sampleInformerFactory informers.SharedInformerFactory, clients *downstream.Manager,
+	recorder record.EventRecorder) {
 	controller := &Controller{
+		ctx:             ctx,
 		clusterInformer: sampleInformerFactory.Clusterprovisioner().V1alpha1().Clusters().Informer(),
 		clusterClient:   kubeconfigClient,
+		clients:         clients,
+		recorder:        recorder,
+	}
+	controller.synced = []cache.InformerSynced{
+		controller.clusterInformer.HasSynced,
+		clients.HasSynced,
 	}
 	controller.clusterInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    controller.addAnnotation,
 		UpdateFunc: controller.updateAnnotation,
 	})
-	stop := make(chan struct{})
-	go controller.clusterInformer.Run(stop)
 	logrus.Infof("Registered %s controller", controller.getName())
 }
 
@@ -47,11 +63,14 @@ func (c *Controller) addAnnotation(obj interface{}) {
 }
 
 func (c *Controller) updateAnnotation(obj interface{}, updated interface{}) {
-	cluster := obj.(*types.Cluster)
+	cluster := updated.(*types.Cluster)
 	c.sync(cluster)
 }
 
 func (c *Controller) sync(cluster *types.Cluster) {
+	if !util.CachesSynced(c.ctx.Done(), c.synced...) {
+		return
+	}
 	if cluster.DeletionTimestamp != nil {
 		return
 	}
@@ -65,6 +84,10 @@ func (c *Controller) sync(cluster *types.Cluster) {
 	version, err := c.getVersion(cluster)
 	if err != nil {
 		logrus.Errorf("Failed to fetch cluster %s version %v", cluster.Name, err)
+		return
+	}
+	if version != "" {
+		metrics.SetKubernetesVersion(cluster.Name, version)
 	}
 
 	currentVersion := ""
@@ -75,41 +98,31 @@ func (c *Controller) sync(cluster *types.Cluster) {
 		return
 	}
 
-	toUpdate := cluster.DeepCopy()
-	if toUpdate.Annotations == nil {
-		toUpdate.Annotations = map[string]string{}
-	}
-	toUpdate.Annotations[kubernetesVersionAnnotation] = version
-
-	for i := 0; i < 3; i++ {
-		_, err = c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Update(toUpdate)
-		if err == nil {
-			break
+	_, err = clusterutil.Update(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		if toUpdate.Annotations == nil {
+			toUpdate.Annotations = map[string]string{}
 		}
-	}
+		toUpdate.Annotations[kubernetesVersionAnnotation] = version
+	})
 	if err != nil {
 		logrus.Debugf("Failed to update cluster %s %v", cluster.Name, err)
+		return
+	}
+	if currentVersion == "" {
+		c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonVersionChanged, "Kubernetes version is %s", version)
+	} else {
+		c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonVersionChanged, "Kubernetes version changed from %s to %s", currentVersion, version)
 	}
-
 }
 
 func (c *Controller) getVersion(cluster *types.Cluster) (string, error) {
-	kubeConfig, err := c.clusterClient.ClusterprovisionerV1alpha1().Kubeconfigs().Get(cluster.Name, v1.GetOptions{})
+	client, err := c.clients.Client(cluster.Name, 0)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return "", nil
 		}
 		return "", err
 	}
-	configPath := kubeConfig.Spec.ConfigPath
-	restConfig, err := clientcmd.BuildConfigFromFlags("", configPath)
-	if err != nil {
-		return "", err
-	}
-	client, err := client.NewForConfig(restConfig)
-	if err != nil {
-		return "", err
-	}
 	//healthcheck passes if we can contact underlying cluster
 	version, err := client.Discovery().ServerVersion()
 	if err != nil {
@@ -1,36 +1,58 @@
 package configgenerator
 
 import (
-	"strings"
-
-	"fmt"
+	"context"
 
 	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
 	kubeconfigclient "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
 	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
+	listers "github.com/rancher/kubecon2018/pkg/client/listers/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	kubeconfigutil "github.com/rancher/kubecon2018/pkg/kubeconfig"
+	"github.com/rancher/kubecon2018/util"
 	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	reasonKubeconfigCreated = "KubeconfigCreated"
+	reasonKubeconfigUpdated = "KubeconfigUpdated"
+	reasonKubeconfigFailed  = "KubeconfigFailed"
 )
 
 type Controller struct {
+	ctx              context.Context
 	clusterInformer  cache.SharedIndexInformer
+	kubeconfigLister listers.KubeconfigLister
+	synced           []cache.InformerSynced
 	kubeconfigClient kubeconfigclient.Interface
+	recorder         record.EventRecorder
+	namespace        string
 }
 
-func Register(kubeconfigClient kubeconfigclient.Interface,
-	sampleInformerFactory informers.SharedInformerFactory) {
+func Register(ctx context.Context, kubeconfigClient kubeconfigclient.Interface,
+	sampleInformerFactory informers.SharedInformerFactory, recorder record.EventRecorder, namespace string) {
+	kubeconfigInformer := sampleInformerFactory.Clusterprovisioner().V1alpha1().Kubeconfigs()
 	controller := &Controller{
+		ctx:              ctx,
 		clusterInformer:  sampleInformerFactory.Clusterprovisioner().V1alpha1().Clusters().Informer(),
+		kubeconfigLister: kubeconfigInformer.Lister(),
 		kubeconfigClient: kubeconfigClient,
+		recorder:         recorder,
+		namespace:        namespace,
+	}
+	controller.synced = []cache.InformerSynced{
+		controller.clusterInformer.HasSynced,
+		kubeconfigInformer.Informer().HasSynced,
 	}
 	controller.clusterInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    controller.addConfig,
 		UpdateFunc: controller.updateConfig,
 	})
-	stop := make(chan struct{})
-	go controller.clusterInformer.Run(stop)
 	logrus.Infof("Registered %s controller", controller.getName())
 }
 
@@ -39,39 +61,42 @@ func (c *Controller) getName() string {
 }
 
 func (c *Controller) sync(cluster *types.Cluster) {
+	if !util.CachesSynced(c.ctx.Done(), c.synced...) {
+		return
+	}
 	if cluster.DeletionTimestamp != nil {
 		return
 	}
 	if !types.ClusterConditionProvisioned.IsTrue(cluster) {
 		return
 	}
-	kubeconfig, err := c.kubeconfigClient.ClusterprovisionerV1alpha1().Kubeconfigs().Get(cluster.Name, metav1.GetOptions{})
+	// the provisioner stores the kubeconfig once the cluster is up, the
+	// imported kubeconfig is referenced as is, it isn't owned by the cluster
+	secretRef := v1.SecretReference{
+		Name:      kubeconfigutil.SecretName(cluster.Name),
+		Namespace: c.namespace,
+	}
+	if cluster.Spec.Import != nil {
+		secretRef.Name = cluster.Spec.Import.KubeconfigSecret
+	}
+	kubeconfig, err := c.kubeconfigLister.Get(cluster.Name)
 	if err != nil && !apierrors.IsNotFound(err) {
 		logrus.Errorf("Failed to fetch kubeconfig by name %s %v", cluster.Name, err)
 		return
 	}
-	path := getKubeConfigPath(cluster)
 
 	if apierrors.IsNotFound(err) || kubeconfig == nil {
 		//create
-		createKubeconfig(cluster, path, c)
-	} else if kubeconfig.Spec.ConfigPath != path {
-		updateKubeconfig(kubeconfig, path, c, cluster)
+		createKubeconfig(cluster, secretRef, c)
+	} else if kubeconfig.Spec.SecretRef != secretRef {
+		updateKubeconfig(kubeconfig, secretRef, c, cluster)
 	}
 }
 
-func createKubeconfig(cluster *types.Cluster, path string, c *Controller) {
-	controller := true
-	ownerRef := metav1.OwnerReference{
-		Name:       cluster.Name,
-		APIVersion: "v1alpha1",
-		UID:        cluster.UID,
-		Kind:       "Cluster",
-		Controller: &controller,
-	}
+func createKubeconfig(cluster *types.Cluster, secretRef v1.SecretReference, c *Controller) {
 	kubeconfig := &types.Kubeconfig{
 		ObjectMeta: metav1.ObjectMeta{
-			OwnerReferences: []metav1.OwnerReference{ownerRef},
+			OwnerReferences: []metav1.OwnerReference{clusterutil.OwnerReference(cluster)},
 			Name:            cluster.Name,
 		},
 		TypeMeta: metav1.TypeMeta{
@@ -79,7 +104,7 @@ func createKubeconfig(cluster *types.Cluster, path string, c *Controller) {
 			APIVersion: "clusterprovisioner.rke.io/v1alpha1",
 		},
 		Spec: types.KubeconfigSpec{
-			ConfigPath: path,
+			SecretRef: secretRef,
 		},
 	}
 	_, err := c.kubeconfigClient.ClusterprovisionerV1alpha1().Kubeconfigs().Create(kubeconfig)
@@ -88,24 +113,21 @@ func createKubeconfig(cluster *types.Cluster, path string, c *Controller) {
 			return
 		}
 		logrus.Errorf("Failed to create kubeconfig for cluster %s %v", cluster.Name, err)
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonKubeconfigFailed, "Failed to create kubeconfig: %v", err)
+		return
 	}
+	c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonKubeconfigCreated, "Created kubeconfig stored in secret %s/%s", secretRef.Namespace, secretRef.Name)
 }
-func updateKubeconfig(kubeconfig *types.Kubeconfig, path string, c *Controller, cluster *types.Cluster) {
+func updateKubeconfig(kubeconfig *types.Kubeconfig, secretRef v1.SecretReference, c *Controller, cluster *types.Cluster) {
 	toUpdate := kubeconfig.DeepCopy()
-	toUpdate.Spec.ConfigPath = path
+	toUpdate.Spec.SecretRef = secretRef
 	_, err := c.kubeconfigClient.ClusterprovisionerV1alpha1().Kubeconfigs().Update(toUpdate)
 	if err != nil {
 		logrus.Errorf("Failed to update kubeconfig for cluster %s %v", cluster.Name, err)
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonKubeconfigFailed, "Failed to update kubeconfig: %v", err)
+		return
 	}
-}
-
-func getKubeConfigPath(cluster *types.Cluster) string {
-	clusterConfigPath := cluster.Spec.ConfigPath
-	splitted := strings.Split(clusterConfigPath, "/")
-	fileName := splitted[len(splitted)-1]
-	path := strings.TrimSuffix(clusterConfigPath, fileName)
-	kubeConfigFileName := fmt.Sprintf("%s/kube_config_%s", path, fileName)
-	return kubeConfigFileName
+	c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonKubeconfigUpdated, "Updated kubeconfig to secret %s/%s", secretRef.Namespace, secretRef.Name)
 }
 
 func (c *Controller) addConfig(obj interface{}) {
@@ -114,6 +136,6 @@ func (c *Controller) addConfig(obj interface{}) {
 }
 
 func (c *Controller) updateConfig(obj interface{}, updated interface{}) {
-	cluster := obj.(*types.Cluster)
+	cluster := updated.(*types.Cluster)
 	c.sync(cluster)
 }
@@ -0,0 +1,77 @@
+package configgenerator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	clusterfake "github.com/rancher/kubecon2018/pkg/client/clientset/versioned/fake"
+	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+const namespace = "cluster-provisioner"
+
+func newCluster(name string, provisioned bool) *types.Cluster {
+	cluster := &types.Cluster{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if provisioned {
+		types.ClusterConditionProvisioned.True(cluster)
+	}
+	return cluster
+}
+
+func TestKubeconfigReference(t *testing.T) {
+	imported := newCluster("imported", true)
+	imported.Spec.Import = &types.ImportSpec{KubeconfigSecret: "imported-kubeconfig-source"}
+	tests := []struct {
+		cluster *types.Cluster
+		// secret is the name of the referenced secret, none when empty
+		secret string
+	}{
+		{cluster: newCluster("provisioned", true), secret: "provisioned-kubeconfig"},
+		{cluster: newCluster("pending", false)},
+		{cluster: imported, secret: "imported-kubeconfig-source"},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := clusterfake.NewSimpleClientset()
+	for _, test := range tests {
+		if _, err := client.ClusterprovisionerV1alpha1().Clusters().Create(test.cluster); err != nil {
+			t.Fatal(err)
+		}
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	Register(ctx, client, factory, record.NewFakeRecorder(100), namespace)
+	factory.Start(ctx.Done())
+
+	for _, test := range tests {
+		timeout := 5 * time.Second
+		if test.secret == "" {
+			// give the controller a moment to wrongly create it
+			timeout = 200 * time.Millisecond
+		}
+		var kubeconfig *types.Kubeconfig
+		err := wait.PollImmediate(10*time.Millisecond, timeout, func() (bool, error) {
+			var err error
+			kubeconfig, err = client.ClusterprovisionerV1alpha1().Kubeconfigs().Get(test.cluster.Name, metav1.GetOptions{})
+			return err == nil, nil
+		})
+		if test.secret == "" {
+			if err == nil {
+				t.Errorf("%s: kubeconfig created before the cluster is provisioned", test.cluster.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: kubeconfig wasn't created", test.cluster.Name)
+		}
+		want := v1.SecretReference{Name: test.secret, Namespace: namespace}
+		if kubeconfig.Spec.SecretRef != want {
+			t.Errorf("%s: kubeconfig references %+v, want %+v", test.cluster.Name, kubeconfig.Spec.SecretRef, want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package controllers
 
 import (
+	"context"
+	"fmt"
 	"time"
 
 	"github.com/rancher/kubecon2018/controllers/annotator"
@@ -8,21 +10,100 @@ import (
 	"github.com/rancher/kubecon2018/controllers/healthchecker"
 	"github.com/rancher/kubecon2018/controllers/provisioner"
 	client "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
+	"github.com/rancher/kubecon2018/pkg/client/clientset/versioned/scheme"
 	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
+	"github.com/rancher/kubecon2018/pkg/downstream"
+	"github.com/rancher/kubecon2018/util"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	rest "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
-func Register(config *rest.Config) error {
+// Options configures the controllers
+type Options struct {
+	// Namespace the operator stores cluster secrets in
+	Namespace string
+	// GracePeriod in-flight provisioning is given to finish on shutdown, see
+	// WorkContext
+	GracePeriod time.Duration
+	// ProvisionerWorkers is the number of clusters provisioned concurrently
+	ProvisionerWorkers int
+	// ProvisionTimeout bounds provisioner runs of clusters that don't set
+	// their own timeout
+	ProvisionTimeout time.Duration
+	// HealthCheck configures the health probes and the remediation of
+	// unhealthy clusters
+	HealthCheck healthchecker.Options
+	// Downstream configures the clients of the provisioned clusters
+	Downstream downstream.Options
+}
+
+// WorkContext returns the context in-flight provisioning runs with, it is
+// cancelled GracePeriod after ctx is done or right away by cancel
+func WorkContext(ctx context.Context, options Options) (context.Context, context.CancelFunc) {
+	return util.WithGracePeriod(ctx, options.GracePeriod)
+}
+
+// Run starts all controllers and blocks until ctx is done and in-flight
+// provisioning, which runs with workCtx, finished or got cancelled
+func Run(ctx, workCtx context.Context, config *rest.Config, options Options) error {
 	client, err := client.NewForConfig(config)
 	if err != nil {
 		return err
 	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return err
+	}
 	clusterInformerFactory := informers.NewSharedInformerFactory(client, time.Second*30)
 
-	provisioner.Register(client, clusterInformerFactory)
-	configgenerator.Register(client, clusterInformerFactory)
-	healthchecker.Register(client, clusterInformerFactory)
-	annotator.Register(client, clusterInformerFactory)
+	// kubeconfig secrets only live in the operator namespace
+	secretInformer := coreinformers.NewSecretInformer(kubeClient, options.Namespace, time.Second*30,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+	broadcaster := record.NewBroadcaster()
+	watcher := broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	defer watcher.Stop()
+	recorder := func(component string) record.EventRecorder {
+		return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+	}
+
+	// the downstream clients are shared by the controllers
+	clients := downstream.NewManager(clusterInformerFactory.Clusterprovisioner().V1alpha1().Kubeconfigs().Informer(),
+		secretInformer, options.Downstream)
+
+	provisionerController := provisioner.Register(ctx, client, kubeClient, clusterInformerFactory, secretInformer, recorder("provisioner"),
+		options.Namespace, workCtx, options.ProvisionTimeout)
+	configgenerator.Register(ctx, client, clusterInformerFactory, recorder("configgenerator"), options.Namespace)
+	healthcheckerController := healthchecker.Register(ctx, client, clusterInformerFactory, clients, recorder("healthchecker"),
+		options.HealthCheck)
+	annotator.Register(ctx, client, clusterInformerFactory, clients, recorder("annotator"))
+
+	clusterInformerFactory.Start(ctx.Done())
+	go secretInformer.Run(ctx.Done())
+
+	logrus.Info("Waiting for informer caches to sync")
+	for informerType, ok := range clusterInformerFactory.WaitForCacheSync(ctx.Done()) {
+		if !ok {
+			return fmt.Errorf("failed to sync cache for %v", informerType)
+		}
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), secretInformer.HasSynced) {
+		return fmt.Errorf("failed to sync secret cache")
+	}
+
+	logrus.Info("Running controllers")
+	provisionerController.Start(ctx, options.ProvisionerWorkers)
+	healthcheckerController.Start(ctx)
+	<-ctx.Done()
 
+	logrus.Info("Stopping controllers, waiting for in-flight provisioning")
+	provisionerController.Shutdown()
+	healthcheckerController.Shutdown()
 	return nil
 }
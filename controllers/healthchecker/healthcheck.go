@@ -1,96 +1,227 @@
 package healthchecker
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
 	"github.com/sirupsen/logrus"
 
 	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
-	client "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
 	clusterclient "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
 	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
 	listers "github.com/rancher/kubecon2018/pkg/client/listers/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/downstream"
+	"github.com/rancher/kubecon2018/util"
+	"github.com/rancher/norman/condition"
+	"k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// workers is the number of clusters probed concurrently
+	workers = 4
+
+	reasonHealthCheckPassed = "HealthCheckPassed"
+	reasonHealthCheckFailed = "HealthCheckFailed"
+)
+
+var (
+	// healthConditions are the conditions owned by the health checker
+	healthConditions = []condition.Cond{
+		types.ClusterConditionReady,
+		types.ClusterConditionAPIReachable,
+		types.ClusterConditionNodesReady,
+		types.ClusterConditionControlPlaneHealthy,
+		types.ClusterConditionEtcdHealthy,
+	}
+
+	// errNoKubeconfig is returned until the kubeconfig of the cluster is
+	// stored, nothing can be checked before
+	errNoKubeconfig = errors.New("no kubeconfig")
 )
 
+// Options configures the health checker
+type Options struct {
+	// Interval and Timeout apply to the clusters that don't set their own,
+	// the client default applies when Timeout isn't positive
+	Interval time.Duration
+	Timeout  time.Duration
+	// Jitter is the maximum fraction of the interval added to it, spreading
+	// the probes of clusters provisioned together
+	Jitter float64
+	// MaxRemediations is the number of clusters remediated within
+	// RemediationWindow at most, remediation actions are disabled when it
+	// isn't positive
+	MaxRemediations   int
+	RemediationWindow time.Duration
+}
+
+// Controller probes the provisioned clusters periodically. Each cluster is
+// requeued after its interval once probed, informer events only start the
+// probing of newly provisioned clusters so status writes don't retrigger it.
 type Controller struct {
 	clusterLister   listers.ClusterLister
 	clusterInformer cache.SharedIndexInformer
 	clusterClient   clusterclient.Interface
+	clients         *downstream.Manager
+	syncQueue       *util.TaskQueue
+	recorder        record.EventRecorder
+	options         Options
+
+	remediationLock sync.Mutex
+	// failures counts the consecutive failed health checks by cluster
+	failures map[string]int
+	// triggered holds when this process requested the recent remediations
+	triggered map[string]time.Time
 }
 
 func Register(
+	ctx context.Context,
 	clusterClient clusterclient.Interface,
-	sampleInformerFactory informers.SharedInformerFactory) {
+	sampleInformerFactory informers.SharedInformerFactory,
+	clients *downstream.Manager,
+	recorder record.EventRecorder, options Options) *Controller {
 	clusterInformer := sampleInformerFactory.Clusterprovisioner().V1alpha1().Clusters()
 
 	controller := &Controller{
 		clusterLister:   clusterInformer.Lister(),
 		clusterInformer: clusterInformer.Informer(),
 		clusterClient:   clusterClient,
+		clients:         clients,
+		recorder:        recorder,
+		options:         options,
+		failures:        map[string]int{},
+		triggered:       map[string]time.Time{},
 	}
+	// failed status writes are retried forever, a cluster leaves the queue
+	// only once it is deleted or no longer provisioned
+	controller.syncQueue = util.NewTaskQueue(controller.getName(), 0, controller.sync, nil)
 	controller.clusterInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    controller.sync,
-		UpdateFunc: controller.handleClusterUpdate,
+		AddFunc: func(obj interface{}) {
+			controller.syncQueue.Enqueue(obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			oldCluster, curCluster := old.(*types.Cluster), cur.(*types.Cluster)
+			if types.ClusterConditionProvisioned.IsTrue(oldCluster) != types.ClusterConditionProvisioned.IsTrue(curCluster) ||
+				!reflect.DeepEqual(oldCluster.Spec.HealthCheck, curCluster.Spec.HealthCheck) {
+				controller.syncQueue.Enqueue(cur)
+			}
+		},
 	})
-	stop := make(chan struct{})
-	go controller.clusterInformer.Run(stop)
 	logrus.Infof("Registered %s controller", controller.getName())
+	return controller
 }
 
-func (c *Controller) getName() string {
-	return "healthchecker"
+// Start runs the workers, it must be called once the informer caches synced
+func (c *Controller) Start(ctx context.Context) {
+	go c.syncQueue.Run(workers, time.Second, ctx.Done())
 }
 
-func (c *Controller) handleClusterUpdate(obj interface{}, updated interface{}) {
-	c.sync(updated)
+// Shutdown waits for the in-flight probes once the controller context is done
+func (c *Controller) Shutdown() {
+	c.syncQueue.Shutdown()
+	logrus.Infof("Stopped %s controller", c.getName())
 }
 
-func (c *Controller) sync(obj interface{}) {
-	cluster := obj.(*types.Cluster)
-	// skip non provisioned clusters
-	if !types.ClusterConditionProvisioned.IsTrue(cluster) {
-		return
-	}
+func (c *Controller) getName() string {
+	return "healthchecker"
+}
 
-	toUpdate, err := types.ClusterConditionReady.Do(cluster, func() (runtime.Object, error) {
-		return cluster, c.validateHealthcheck(cluster)
-	})
+func (c *Controller) sync(key string) error {
+	cluster, err := c.clusterLister.Get(key)
 	if err != nil {
-		logrus.Errorf("Failed to validate healthcheck on cluster %s %v", cluster.Name, err)
+		if apierrors.IsNotFound(err) {
+			c.resetFailures(key)
+			return nil
+		}
+		return err
 	}
+	// skip non provisioned clusters, they are enqueued again once provisioned
+	if !types.ClusterConditionProvisioned.IsTrue(cluster) {
+		c.resetFailures(key)
+		return nil
+	}
+	if err := c.probe(cluster); err != nil {
+		return err
+	}
+	c.syncQueue.EnqueueAfter(key, c.delayOf(cluster))
+	return nil
+}
 
-	for i := 0; i < 3; i++ {
-		_, err = c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Update(toUpdate.(*types.Cluster))
-		if err == nil {
-			break
+// probe runs the probes against the cluster and writes the health conditions
+// when they changed
+func (c *Controller) probe(cluster *types.Cluster) error {
+	toUpdate := cluster.DeepCopy()
+	err := c.validateHealthcheck(toUpdate)
+	if err == errNoKubeconfig {
+		return nil
+	} else if err != nil {
+		logrus.Debugf("Health check of cluster %s failed %v", cluster.Name, err)
+	}
+	// only report transitions, the check runs on every resync
+	if types.ClusterConditionReady.GetStatus(toUpdate) != types.ClusterConditionReady.GetStatus(cluster) {
+		if err != nil {
+			c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonHealthCheckFailed, "Health check failed: %s",
+				types.ClusterConditionReady.GetMessage(toUpdate))
+		} else {
+			c.recorder.Event(cluster, v1.EventTypeNormal, reasonHealthCheckPassed, "Health check passed")
 		}
 	}
+
+	_, err = clusterutil.UpdateStatus(c.clusterClient, cluster, func(latest *types.Cluster) {
+		for _, cond := range healthConditions {
+			clusterutil.CopyCondition(latest, toUpdate, cond)
+		}
+	})
 	if err != nil {
-		logrus.Debugf("Failed to update cluster %s %v", cluster.Name, err)
+		return fmt.Errorf("error updating health of cluster %s %v", cluster.Name, err)
 	}
+	trigger := types.ClusterConditionReady.GetReason(toUpdate)
+	if err := c.checkRemediation(cluster, types.ClusterConditionReady.IsTrue(toUpdate), trigger); err != nil {
+		return fmt.Errorf("error remediating cluster %s %v", cluster.Name, err)
+	}
+	return nil
 }
 
+// validateHealthcheck runs the probes against the cluster and sets the health
+// conditions on it
 func (c *Controller) validateHealthcheck(cluster *types.Cluster) error {
-	kubeConfig, err := c.clusterClient.ClusterprovisionerV1alpha1().Kubeconfigs().Get(cluster.Name, v1.GetOptions{})
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			return nil
-		}
+	client, err := c.clients.Client(cluster.Name, c.timeoutOf(cluster))
+	if apierrors.IsNotFound(err) {
+		return errNoKubeconfig
+	} else if err != nil {
+		unreachable(cluster, err)
 		return err
 	}
-	configPath := kubeConfig.Spec.ConfigPath
-	restConfig, err := clientcmd.BuildConfigFromFlags("", configPath)
-	if err != nil {
-		return err
+	return runProbes(cluster, client)
+}
+
+// delayOf returns the time until the next probe of the cluster, its interval
+// plus a random jitter
+func (c *Controller) delayOf(cluster *types.Cluster) time.Duration {
+	interval := c.options.Interval
+	spec := cluster.Spec.HealthCheck
+	if spec != nil && spec.Interval != nil && spec.Interval.Duration > 0 {
+		interval = spec.Interval.Duration
 	}
-	client, err := client.NewForConfig(restConfig)
-	if err != nil {
-		return err
+	if spec != nil && spec.Jitter != nil && spec.Jitter.Duration >= 0 {
+		return interval + time.Duration(rand.Float64()*float64(spec.Jitter.Duration))
+	}
+	return wait.Jitter(interval, c.options.Jitter)
+}
+
+func (c *Controller) timeoutOf(cluster *types.Cluster) time.Duration {
+	if spec := cluster.Spec.HealthCheck; spec != nil && spec.Timeout != nil && spec.Timeout.Duration > 0 {
+		return spec.Timeout.Duration
 	}
-	//healthcheck passes if we can contact underlying cluster
-	_, err = client.Discovery().ServerVersion()
-	return err
+	return c.options.Timeout
 }
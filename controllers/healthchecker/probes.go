@@ -0,0 +1,278 @@
+package healthchecker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/metrics"
+	"github.com/rancher/norman/condition"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// maxAPILatency is the time the health endpoints get to answer before the
+	// API is considered unhealthy
+	maxAPILatency = 5 * time.Second
+
+	reasonAPIUnreachable      = "APIUnreachable"
+	reasonSlowResponse        = "SlowResponse"
+	reasonNodesNotReady       = "NodesNotReady"
+	reasonComponentsUnhealthy = "ComponentsUnhealthy"
+	reasonPodsUnhealthy       = "PodsUnhealthy"
+)
+
+// corePods are the k8s-app or app label values of the kube-system pods rke
+// deploys as addons: DNS, metrics and the network plugins. Other pods of
+// kube-system don't weigh on the control plane health.
+var corePods = map[string]bool{
+	"kube-dns":                true,
+	"kube-dns-autoscaler":     true,
+	"coredns-autoscaler":      true,
+	"metrics-server":          true,
+	"flannel":                 true,
+	"canal":                   true,
+	"calico-node":             true,
+	"calico-kube-controllers": true,
+	"weave-net":               true,
+}
+
+// probe is a health check reported as its own cluster condition
+type probe struct {
+	// name is the probe label of the health metrics
+	name  string
+	cond  condition.Cond
+	check func(cluster *types.Cluster, client kubernetes.Interface) error
+}
+
+// probes run in order, the ones after the API probe are skipped when the API
+// is unreachable
+var probes = []probe{
+	{name: "api", cond: types.ClusterConditionAPIReachable, check: checkAPI},
+	{name: "nodes", cond: types.ClusterConditionNodesReady, check: checkNodes},
+	{name: "controlplane", cond: types.ClusterConditionControlPlaneHealthy, check: checkControlPlane},
+	{name: "etcd", cond: types.ClusterConditionEtcdHealthy, check: checkEtcd},
+}
+
+// runProbes sets the condition of every probe on cluster, and Ready to
+// whether all of them passed. It returns the error of the first failed probe.
+func runProbes(cluster *types.Cluster, client kubernetes.Interface) error {
+	var failed []string
+	var firstErr error
+	var firstCond condition.Cond
+	for i, p := range probes {
+		start := time.Now()
+		err := p.check(cluster, client)
+		metrics.ObserveHealthProbe(cluster.Name, p.name, start, err)
+		setCondition(cluster, p.cond, err)
+		if err == nil {
+			continue
+		}
+		failed = append(failed, fmt.Sprintf("%s: %v", p.cond, err))
+		if firstErr == nil {
+			firstErr, firstCond = err, p.cond
+		}
+		if i == 0 {
+			// nothing else can be checked
+			for _, skipped := range probes[1:] {
+				unknown(cluster, skipped.cond, reasonAPIUnreachable)
+			}
+			break
+		}
+	}
+	if firstErr == nil {
+		setCondition(cluster, types.ClusterConditionReady, nil)
+		return nil
+	}
+	// Ready carries the condition that failed first as reason
+	setCondition(cluster, types.ClusterConditionReady, condition.Error(string(firstCond), fmt.Errorf("%s", strings.Join(failed, "; "))))
+	return firstErr
+}
+
+// unreachable marks the API unreachable with err, and the other probes
+// unknown
+func unreachable(cluster *types.Cluster, err error) {
+	setCondition(cluster, types.ClusterConditionAPIReachable, condition.Error(reasonAPIUnreachable, err))
+	for _, p := range probes[1:] {
+		unknown(cluster, p.cond, reasonAPIUnreachable)
+	}
+	setCondition(cluster, types.ClusterConditionReady, condition.Error(string(types.ClusterConditionAPIReachable), err))
+}
+
+func setCondition(cluster *types.Cluster, cond condition.Cond, err error) {
+	if err == nil {
+		cond.True(cluster)
+		cond.Reason(cluster, "")
+		cond.Message(cluster, "")
+		return
+	}
+	cond.False(cluster)
+	cond.ReasonAndMessageFromError(cluster, err)
+}
+
+func unknown(cluster *types.Cluster, cond condition.Cond, reason string) {
+	cond.Unknown(cluster)
+	cond.Reason(cluster, reason)
+	cond.Message(cluster, "")
+}
+
+// checkAPI queries /healthz and /readyz, the latter is skipped on servers
+// that predate it
+func checkAPI(cluster *types.Cluster, client kubernetes.Interface) error {
+	start := time.Now()
+	for _, path := range []string{"/healthz", "/readyz"} {
+		err := client.CoreV1().RESTClient().Get().AbsPath(path).Do().Error()
+		if path == "/readyz" && apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return condition.Error(reasonAPIUnreachable, fmt.Errorf("%s failed %v", path, err))
+		}
+	}
+	if latency := time.Since(start); latency > maxAPILatency {
+		return condition.Error(reasonSlowResponse, fmt.Errorf("health endpoints answered in %v", latency.Round(time.Second)))
+	}
+	return nil
+}
+
+// checkNodes verifies the nodes of the RKE spec are registered and Ready.
+// Without a spec, imported clusters for instance, all nodes must be Ready.
+func checkNodes(cluster *types.Cluster, client kubernetes.Interface) error {
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	ready := map[string]bool{}
+	for _, node := range nodes.Items {
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == v1.NodeReady {
+				ready[node.Name] = cond.Status == v1.ConditionTrue
+			}
+		}
+	}
+
+	var expected []string
+	if cluster.Spec.Import == nil && cluster.Spec.RKEConfig != nil {
+		for _, node := range cluster.Spec.RKEConfig.Nodes {
+			// rke registers nodes by their hostname override
+			name := node.HostnameOverride
+			if name == "" {
+				name = node.Address
+			}
+			expected = append(expected, name)
+		}
+	} else {
+		for name := range ready {
+			expected = append(expected, name)
+		}
+		if len(expected) == 0 {
+			return condition.Error(reasonNodesNotReady, fmt.Errorf("no nodes registered"))
+		}
+	}
+
+	var notReady []string
+	for _, name := range expected {
+		if !ready[name] {
+			notReady = append(notReady, name)
+		}
+	}
+	if len(notReady) > 0 {
+		sort.Strings(notReady)
+		return condition.Error(reasonNodesNotReady, fmt.Errorf("%d/%d nodes ready, not ready or missing: %s",
+			len(expected)-len(notReady), len(expected), strings.Join(notReady, ", ")))
+	}
+	return nil
+}
+
+// checkControlPlane verifies the scheduler and controller manager component
+// statuses, and the core addon pods of kube-system
+func checkControlPlane(cluster *types.Cluster, client kubernetes.Interface) error {
+	if err := checkComponents(client, func(name string) bool { return !strings.HasPrefix(name, "etcd-") }); err != nil {
+		return err
+	}
+	pods, err := client.CoreV1().Pods(metav1.NamespaceSystem).List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	var unhealthy []string
+	for _, pod := range pods.Items {
+		if !isCorePod(pod) {
+			continue
+		}
+		if !podHealthy(pod) {
+			unhealthy = append(unhealthy, pod.Name)
+		}
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return condition.Error(reasonPodsUnhealthy, fmt.Errorf("kube-system pods not ready: %s", strings.Join(unhealthy, ", ")))
+	}
+	return nil
+}
+
+// checkEtcd verifies the component statuses of the etcd members
+func checkEtcd(cluster *types.Cluster, client kubernetes.Interface) error {
+	return checkComponents(client, func(name string) bool { return strings.HasPrefix(name, "etcd-") })
+}
+
+// checkComponents verifies the component statuses matching include are
+// healthy, at least one has to match
+func checkComponents(client kubernetes.Interface, include func(name string) bool) error {
+	statuses, err := client.CoreV1().ComponentStatuses().List(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	found := false
+	var unhealthy []string
+	for _, status := range statuses.Items {
+		if !include(status.Name) {
+			continue
+		}
+		found = true
+		healthy := false
+		message := ""
+		for _, cond := range status.Conditions {
+			if cond.Type == v1.ComponentHealthy {
+				healthy = cond.Status == v1.ConditionTrue
+				message = cond.Error
+			}
+		}
+		if !healthy {
+			unhealthy = append(unhealthy, fmt.Sprintf("%s (%s)", status.Name, message))
+		}
+	}
+	if !found {
+		return condition.Error(reasonComponentsUnhealthy, fmt.Errorf("no component statuses reported"))
+	}
+	if len(unhealthy) > 0 {
+		sort.Strings(unhealthy)
+		return condition.Error(reasonComponentsUnhealthy, fmt.Errorf("unhealthy: %s", strings.Join(unhealthy, ", ")))
+	}
+	return nil
+}
+
+func isCorePod(pod v1.Pod) bool {
+	return corePods[pod.Labels["k8s-app"]] || corePods[pod.Labels["app"]]
+}
+
+// podHealthy tells whether the pod completed or runs with all its containers
+// ready. Terminated pods, evicted ones for instance, are ignored: their
+// replacement is checked instead.
+func podHealthy(pod v1.Pod) bool {
+	switch pod.Status.Phase {
+	case v1.PodSucceeded, v1.PodFailed:
+		return true
+	case v1.PodRunning:
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == v1.PodReady {
+				return cond.Status == v1.ConditionTrue
+			}
+		}
+	}
+	return false
+}
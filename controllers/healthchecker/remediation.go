@@ -0,0 +1,129 @@
+package healthchecker
+
+import (
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/remediation"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	reasonUnhealthy            = "Unhealthy"
+	reasonRemediationTriggered = "RemediationTriggered"
+	reasonRemediationThrottled = "RemediationThrottled"
+)
+
+// checkRemediation counts the consecutive failed health checks of the
+// cluster, and requests its remediation from the provisioner once they reach
+// the threshold of its policy. trigger is the reason of the last failure.
+func (c *Controller) checkRemediation(cluster *types.Cluster, healthy bool, trigger string) error {
+	if healthy {
+		c.resetFailures(cluster.Name)
+		return nil
+	}
+	c.remediationLock.Lock()
+	c.failures[cluster.Name]++
+	failures := c.failures[cluster.Name]
+	c.remediationLock.Unlock()
+
+	policy := cluster.Spec.Remediation
+	if policy == nil || cluster.Spec.Import != nil || failures < policy.FailureThreshold {
+		return nil
+	}
+	if _, ok := cluster.Annotations[remediation.Annotation]; ok {
+		return nil
+	}
+	if last := remediation.LastAttempt(cluster); last != nil && time.Since(last.StartedAt.Time) < remediation.Cooldown(cluster) {
+		logrus.Debugf("Cluster [%s] failed %d health checks, remediation is cooling down", cluster.Name, failures)
+		return nil
+	}
+
+	action := remediation.Action(cluster)
+	if action == types.RemediationActionNone {
+		// nothing to run, the attempt only reports the failures once per
+		// cooldown
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonUnhealthy, "Failed %d health checks in a row: %s", failures, trigger)
+		_, err := clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+			remediation.Start(toUpdate, action, trigger)
+			remediation.Finish(toUpdate, nil)
+		})
+		c.resetFailures(cluster.Name)
+		return err
+	}
+	if !c.reserveRemediation(cluster.Name) {
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonRemediationThrottled,
+			"Holding back %s remediation, %d clusters were remediated in the last %v", action, c.options.MaxRemediations, c.options.RemediationWindow)
+		return nil
+	}
+
+	logrus.Infof("Cluster [%s] failed %d health checks in a row, requesting %s remediation", cluster.Name, failures, action)
+	c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonRemediationTriggered,
+		"Failed %d health checks in a row, requesting %s remediation: %s", failures, action, trigger)
+	_, err := clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		remediation.Start(toUpdate, action, trigger)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = clusterutil.Update(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		if toUpdate.Annotations == nil {
+			toUpdate.Annotations = map[string]string{}
+		}
+		toUpdate.Annotations[remediation.Annotation] = string(action)
+	})
+	c.resetFailures(cluster.Name)
+	return err
+}
+
+// reserveRemediation tells whether one more cluster may be remediated under
+// the global cap, and counts it if so. The cap bounds the number of clusters
+// remediated within the window, so a network partition failing the health
+// checks of the whole fleet doesn't reprovision all of it.
+func (c *Controller) reserveRemediation(name string) bool {
+	if c.options.MaxRemediations <= 0 {
+		return false
+	}
+	c.remediationLock.Lock()
+	defer c.remediationLock.Unlock()
+
+	since := time.Now().Add(-c.options.RemediationWindow)
+	// the remediations triggered by this process may not be in the cache yet
+	recent := map[string]bool{}
+	for cluster, at := range c.triggered {
+		if at.After(since) {
+			recent[cluster] = true
+		} else {
+			delete(c.triggered, cluster)
+		}
+	}
+	clusters, err := c.clusterLister.List(labels.Everything())
+	if err != nil {
+		logrus.Errorf("Failed to list clusters %v", err)
+		return false
+	}
+	for _, cluster := range clusters {
+		if _, ok := cluster.Annotations[remediation.Annotation]; ok {
+			recent[cluster.Name] = true
+		}
+		for _, attempt := range cluster.Status.Remediations {
+			if attempt.Action != types.RemediationActionNone && attempt.StartedAt.Time.After(since) {
+				recent[cluster.Name] = true
+			}
+		}
+	}
+	if !recent[name] && len(recent) >= c.options.MaxRemediations {
+		return false
+	}
+	c.triggered[name] = time.Now()
+	return true
+}
+
+func (c *Controller) resetFailures(name string) {
+	c.remediationLock.Lock()
+	defer c.remediationLock.Unlock()
+	delete(c.failures, name)
+}
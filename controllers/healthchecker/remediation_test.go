@@ -0,0 +1,190 @@
+package healthchecker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
+	"github.com/rancher/kubecon2018/pkg/clusterutil/clustertest"
+	"github.com/rancher/kubecon2018/pkg/remediation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// newTestController returns a health checker over a fake clientset holding
+// the clusters, its cache is filled by hand
+func newTestController(t *testing.T, options Options, clusters ...*types.Cluster) *Controller {
+	var objects []runtime.Object
+	for _, cluster := range clusters {
+		objects = append(objects, cluster)
+	}
+	client, err := clustertest.NewClientset(objects...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := informers.NewSharedInformerFactory(client, 0)
+	c := Register(context.Background(), client, factory, nil, record.NewFakeRecorder(100), options)
+	indexer := factory.Clusterprovisioner().V1alpha1().Clusters().Informer().GetIndexer()
+	for _, cluster := range clusters {
+		if err := indexer.Add(cluster); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func newCluster(name string, action types.RemediationAction) *types.Cluster {
+	return &types.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: types.ClusterSpec{
+			Remediation: &types.RemediationPolicy{
+				FailureThreshold: 3,
+				Action:           action,
+			},
+		},
+	}
+}
+
+func TestCheckRemediation(t *testing.T) {
+	options := Options{MaxRemediations: 1, RemediationWindow: time.Hour}
+	tests := []struct {
+		name    string
+		cluster func() *types.Cluster
+		options Options
+		checks  []bool
+		// attempts is the number of remediation attempts recorded
+		attempts  int
+		requested bool
+	}{
+		{
+			name:    "below the failure threshold",
+			cluster: func() *types.Cluster { return newCluster("below", types.RemediationActionReprovision) },
+			options: options,
+			checks:  []bool{false, false},
+		},
+		{
+			name:      "at the failure threshold",
+			cluster:   func() *types.Cluster { return newCluster("at", types.RemediationActionReprovision) },
+			options:   options,
+			checks:    []bool{false, false, false},
+			attempts:  1,
+			requested: true,
+		},
+		{
+			name:    "reset by a passed check",
+			cluster: func() *types.Cluster { return newCluster("reset", types.RemediationActionReprovision) },
+			options: options,
+			checks:  []bool{false, false, true, false, false},
+		},
+		{
+			name: "cooling down",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("cooldown", types.RemediationActionReprovision)
+				remediation.Start(cluster, types.RemediationActionReprovision, "earlier")
+				remediation.Finish(cluster, nil)
+				return cluster
+			},
+			options:  options,
+			checks:   []bool{false, false, false},
+			attempts: 1,
+		},
+		{
+			name: "cooled down",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("cooled", types.RemediationActionReprovision)
+				cluster.Spec.Remediation.Cooldown = &metav1.Duration{Duration: time.Minute}
+				remediation.Start(cluster, types.RemediationActionReprovision, "earlier")
+				remediation.Finish(cluster, nil)
+				cluster.Status.Remediations[0].StartedAt = metav1.NewTime(time.Now().Add(-time.Hour))
+				return cluster
+			},
+			options:   Options{MaxRemediations: 1, RemediationWindow: time.Minute},
+			checks:    []bool{false, false, false},
+			attempts:  2,
+			requested: true,
+		},
+		{
+			name:    "disabled by a zero cap",
+			cluster: func() *types.Cluster { return newCluster("disabled", types.RemediationActionReprovision) },
+			options: Options{RemediationWindow: time.Hour},
+			checks:  []bool{false, false, false},
+		},
+		{
+			name:    "reported without an action",
+			cluster: func() *types.Cluster { return newCluster("none", types.RemediationActionNone) },
+			options: options,
+			checks:  []bool{false, false, false},
+			// the failures are reported on a finished attempt
+			attempts: 1,
+		},
+	}
+	for _, test := range tests {
+		cluster := test.cluster()
+		c := newTestController(t, test.options, cluster)
+		for i, healthy := range test.checks {
+			latest, err := c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Get(cluster.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.checkRemediation(latest, healthy, fmt.Sprintf("check %d", i)); err != nil {
+				t.Fatalf("%s: check %d failed: %v", test.name, i, err)
+			}
+		}
+		latest, err := c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Get(cluster.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(latest.Status.Remediations); n != test.attempts {
+			t.Errorf("%s: %d remediation attempts, want %d", test.name, n, test.attempts)
+		}
+		if _, requested := latest.Annotations[remediation.Annotation]; requested != test.requested {
+			t.Errorf("%s: remediation requested %v, want %v", test.name, requested, test.requested)
+		}
+	}
+}
+
+func TestReserveRemediationCap(t *testing.T) {
+	const clusters = 10
+	c := newTestController(t, Options{MaxRemediations: 3, RemediationWindow: time.Hour})
+
+	var wg sync.WaitGroup
+	reserved := make(chan string, clusters)
+	for i := 0; i < clusters; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if c.reserveRemediation(name) {
+				reserved <- name
+			}
+		}(fmt.Sprintf("cluster-%d", i))
+	}
+	wg.Wait()
+	close(reserved)
+
+	var names []string
+	for name := range reserved {
+		names = append(names, name)
+	}
+	if len(names) != 3 {
+		t.Fatalf("reserved %v, want 3 clusters", names)
+	}
+	// a cluster remediated within the window doesn't count twice
+	if !c.reserveRemediation(names[0]) {
+		t.Errorf("%s was refused a second remediation within the cap", names[0])
+	}
+	if c.reserveRemediation("cluster-other") {
+		t.Error("cap was exceeded")
+	}
+}
+
+func TestReserveRemediationDisabled(t *testing.T) {
+	c := newTestController(t, Options{RemediationWindow: time.Hour})
+	if c.reserveRemediation("cluster") {
+		t.Error("remediation reserved with a zero cap")
+	}
+}
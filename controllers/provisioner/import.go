@@ -0,0 +1,66 @@
+package provisioner
+
+import (
+	"fmt"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/kubeconfig"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	reasonImported     = "Imported"
+	reasonImportFailed = "ImportFailed"
+)
+
+// handleClusterImport marks an imported cluster provisioned once its
+// kubeconfig secret is readable. No provisioner backend ever runs for it, its
+// deletion always orphans it.
+func (c *Controller) handleClusterImport(cluster *types.Cluster) error {
+	// the finalizer lets the deletion protection apply
+	initialized, err := c.initialize(cluster, c.getName())
+	if err != nil {
+		return fmt.Errorf("error initializing cluster %s %v", cluster.Name, err)
+	}
+	cluster = initialized
+	toUpdate := cluster.DeepCopy()
+	_, importErr := types.ClusterConditionProvisioned.Do(toUpdate, func() (runtime.Object, error) {
+		return toUpdate, c.checkImport(cluster)
+	})
+	_, err = clusterutil.UpdateStatus(c.clusterClient, cluster, func(updated *types.Cluster) {
+		clusterutil.CopyCondition(updated, toUpdate, types.ClusterConditionProvisioned)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating cluster %s %v", cluster.Name, err)
+	}
+	if importErr != nil {
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonImportFailed, "Failed to import cluster: %v", importErr)
+		return fmt.Errorf("error importing cluster %s %v", cluster.Name, importErr)
+	}
+	if !types.ClusterConditionProvisioned.IsTrue(cluster) {
+		c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonImported, "Imported cluster from secret %s", cluster.Spec.Import.KubeconfigSecret)
+		logrus.Infof("Imported cluster %v", cluster.Name)
+	}
+	return nil
+}
+
+// checkImport verifies the imported kubeconfig can be loaded, whether the
+// cluster is reachable is up to the health checker
+func (c *Controller) checkImport(cluster *types.Cluster) error {
+	name := cluster.Spec.Import.KubeconfigSecret
+	secret, err := c.getSecret(name)
+	if err != nil {
+		return err
+	}
+	data, ok := secret.Data[types.KubeconfigSecretKey]
+	if !ok {
+		return fmt.Errorf("secret %s/%s has no %s key", secret.Namespace, name, types.KubeconfigSecretKey)
+	}
+	if _, err := kubeconfig.FromBytes(data); err != nil {
+		return fmt.Errorf("invalid kubeconfig in secret %s/%s %v", secret.Namespace, name, err)
+	}
+	return nil
+}
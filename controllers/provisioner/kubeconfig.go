@@ -0,0 +1,70 @@
+package provisioner
+
+import (
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	kubeconfigutil "github.com/rancher/kubecon2018/pkg/kubeconfig"
+	backends "github.com/rancher/kubecon2018/pkg/provisioner"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const reasonKubeconfigUpdated = "KubeconfigUpdated"
+
+// storeKubeconfig stores the kubeconfig of a freshly provisioned cluster in
+// the secret owned by the cluster, the configgenerator controller references
+// it from the Kubeconfig resource
+func (c *Controller) storeKubeconfig(cluster *types.Cluster) error {
+	backend, err := backends.ForCluster(cluster)
+	if err != nil {
+		return err
+	}
+	content, err := backend.KubeConfig(cluster)
+	if err != nil {
+		return err
+	}
+
+	name := kubeconfigutil.SecretName(cluster.Name)
+	secrets := c.kubeClient.CoreV1().Secrets(c.namespace)
+	secret, err := c.secretLister.Secrets(c.namespace).Get(name)
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{clusterutil.OwnerReference(cluster)},
+				Name:            name,
+				Namespace:       c.namespace,
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: map[string][]byte{
+				types.KubeconfigSecretKey: []byte(content),
+			},
+		})
+		if !apierrors.IsAlreadyExists(err) {
+			return err
+		}
+		// the cache lags behind, update the stored secret instead
+		secret, err = secrets.Get(name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	if string(secret.Data[types.KubeconfigSecretKey]) == content && clusterutil.IsOwner(cluster, secret.OwnerReferences) {
+		return nil
+	}
+	toUpdate := secret.DeepCopy()
+	if toUpdate.Data == nil {
+		toUpdate.Data = map[string][]byte{}
+	}
+	toUpdate.Data[types.KubeconfigSecretKey] = []byte(content)
+	if !clusterutil.IsOwner(cluster, toUpdate.OwnerReferences) {
+		// the secret was orphaned by a previous cluster of the same name
+		toUpdate.OwnerReferences = append(toUpdate.OwnerReferences, clusterutil.OwnerReference(cluster))
+	}
+	if _, err := secrets.Update(toUpdate); err != nil {
+		return err
+	}
+	c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonKubeconfigUpdated, "Updated kubeconfig in secret %s/%s", c.namespace, name)
+	return nil
+}
@@ -1,79 +1,249 @@
 package provisioner
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"os/exec"
+	"sync"
 	"time"
 
 	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
 	clusterclient "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
 	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
 	listers "github.com/rancher/kubecon2018/pkg/client/listers/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	kubeconfigutil "github.com/rancher/kubecon2018/pkg/kubeconfig"
+	"github.com/rancher/kubecon2018/pkg/metrics"
+	"github.com/rancher/kubecon2018/pkg/plan"
+	backends "github.com/rancher/kubecon2018/pkg/provisioner"
+	"github.com/rancher/kubecon2018/pkg/remediation"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+	"github.com/rancher/kubecon2018/pkg/rkestate"
+	"github.com/rancher/kubecon2018/pkg/sshkeys"
 	"github.com/rancher/kubecon2018/util"
+	"github.com/rancher/norman/condition"
 	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/meta"
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// CancelAnnotation aborts the in-flight provisioner run of the cluster,
+	// no further run starts until it is removed
+	CancelAnnotation = "clusterprovisioner.rke.io/cancel"
+	// ProtectionAnnotation holds off the removal of a deleted cluster until it
+	// is cleared
+	ProtectionAnnotation = "clusterprovisioner.rke.io/deletion-protection"
+
+	// maxRetries is the number of consecutive failures after which a cluster
+	// is parked until its spec changes
+	maxRetries = 10
+
+	reasonRetriesExhausted   = "RetriesExhausted"
+	reasonProvisioning       = "Provisioning"
+	reasonProvisioned        = "Provisioned"
+	reasonProvisioningFailed = "ProvisioningFailed"
+	reasonCleanupStarted     = "CleanupStarted"
+	reasonCleanupFinished    = "CleanupFinished"
+	reasonCleanupFailed      = "CleanupFailed"
+	reasonTimeout            = "Timeout"
+	reasonCancelled          = "Cancelled"
+	reasonPlanned            = "Planned"
+	reasonApprovalRequired   = "ApprovalRequired"
+	reasonDeletionBlocked    = "DeletionBlocked"
+	reasonOrphaned           = "Orphaned"
 )
 
 type Controller struct {
 	clusterLister   listers.ClusterLister
 	clusterInformer cache.SharedIndexInformer
 	clusterClient   clusterclient.Interface
+	kubeClient      kubernetes.Interface
+	secretLister    corelisters.SecretLister
+	namespace       string
 	syncQueue       *util.TaskQueue
+	recorder        record.EventRecorder
+	// workCtx is passed to the provisioner backends, it outlives the
+	// controller context by the shutdown grace period on termination, but not
+	// when the leader lease is lost
+	workCtx context.Context
+	// timeout bounds the runs of clusters that don't set their own
+	timeout time.Duration
+
+	runningLock sync.Mutex
+	running     map[string]*run
+
+	// blocked holds the clusters whose deletion was reported blocked
+	blockedLock sync.Mutex
+	blocked     map[string]bool
+}
+
+// run is an in-flight provisioner run
+type run struct {
+	cancel    context.CancelFunc
+	cancelled bool
 }
 
 func Register(
-	clusterClient clusterclient.Interface,
-	sampleInformerFactory informers.SharedInformerFactory) {
+	ctx context.Context,
+	clusterClient clusterclient.Interface, kubeClient kubernetes.Interface,
+	sampleInformerFactory informers.SharedInformerFactory, secretInformer cache.SharedIndexInformer,
+	recorder record.EventRecorder, namespace string, workCtx context.Context, timeout time.Duration) *Controller {
 	clusterInformer := sampleInformerFactory.Clusterprovisioner().V1alpha1().Clusters()
 
 	controller := &Controller{
 		clusterLister:   clusterInformer.Lister(),
 		clusterInformer: clusterInformer.Informer(),
 		clusterClient:   clusterClient,
+		kubeClient:      kubeClient,
+		secretLister:    corelisters.NewSecretLister(secretInformer.GetIndexer()),
+		namespace:       namespace,
+		recorder:        recorder,
+		timeout:         timeout,
+		running:         map[string]*run{},
+		blocked:         map[string]bool{},
+		workCtx:         workCtx,
 	}
-	controller.syncQueue = util.NewTaskQueue(controller.sync)
+	controller.syncQueue = util.NewTaskQueue(controller.getName(), maxRetries, controller.sync, controller.park)
 	controller.clusterInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			controller.syncQueue.Enqueue(obj)
 		},
 		UpdateFunc: func(old, cur interface{}) {
-			controller.syncQueue.Enqueue(cur)
+			// a parked cluster is retried once its spec changed or it got deleted
+			oldCluster, curCluster := old.(*types.Cluster), cur.(*types.Cluster)
+			if oldCluster.Generation != curCluster.Generation ||
+				(oldCluster.DeletionTimestamp == nil) != (curCluster.DeletionTimestamp == nil) ||
+				(isCancelled(oldCluster) && !isCancelled(curCluster)) ||
+				oldCluster.Annotations[RollbackAnnotation] != curCluster.Annotations[RollbackAnnotation] ||
+				oldCluster.Annotations[remediation.Annotation] != curCluster.Annotations[remediation.Annotation] ||
+				isProtected(oldCluster) != isProtected(curCluster) {
+				controller.syncQueue.Unpark(cur)
+			}
+			if isCancelled(curCluster) {
+				controller.cancel(curCluster)
+			}
+			// status writes, ours included, and resyncs don't enqueue, so a
+			// failing cluster waits for its retry backoff
+			if needsSync(oldCluster, curCluster) {
+				controller.syncQueue.Enqueue(cur)
+			} else if oldCluster.ResourceVersion == curCluster.ResourceVersion &&
+				curCluster.Spec.RKEConfig == nil && curCluster.Spec.ConfigPath != "" &&
+				!controller.syncQueue.Retrying(cur) {
+				// a legacy config file is only noticed to change on resync
+				controller.syncQueue.Enqueue(cur)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			// a cluster gone for good mustn't stay parked or blocked, a new
+			// cluster of the same name starts afresh
+			controller.syncQueue.Unpark(obj)
+			if key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj); err == nil {
+				controller.setBlocked(key, false)
+			}
+		},
+	})
+	// rotating an SSH key reprovisions the clusters using it, an imported
+	// cluster is checked again once its kubeconfig is updated
+	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.enqueueSecretUsers,
+		UpdateFunc: func(old, cur interface{}) {
+			if old.(*v1.Secret).ResourceVersion != cur.(*v1.Secret).ResourceVersion {
+				controller.enqueueSecretUsers(cur)
+			}
 		},
 	})
-	stop := make(chan struct{})
-	go controller.syncQueue.Run(time.Second, stop)
 	logrus.Infof("Registered %s controller", controller.getName())
+	return controller
+}
+
+// Start runs the workers, it must be called once the informer caches synced
+func (c *Controller) Start(ctx context.Context, workers int) {
+	go c.syncQueue.Run(workers, time.Second, ctx.Done())
+}
+
+// Shutdown waits for the in-flight provisioning to finish once the controller
+// context is done
+func (c *Controller) Shutdown() {
+	c.syncQueue.Shutdown()
+	logrus.Infof("Stopped %s controller", c.getName())
 }
 
 func (c *Controller) getName() string {
 	return "provisioner"
 }
 
-func (c *Controller) sync(key string) {
+func (c *Controller) sync(key string) error {
 	cluster, err := c.clusterLister.Get(key)
 	if err != nil {
-		c.syncQueue.Requeue(key, err)
-		return
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
 	}
 
+	if isCancelled(cluster) {
+		logrus.Infof("Cluster [%s] has the %s annotation, skipping", cluster.Name, CancelAnnotation)
+		return nil
+	}
 	if cluster.DeletionTimestamp != nil {
-		err = c.handleClusterRemove(cluster)
+		return c.handleClusterRemove(cluster)
+	}
+	if action, ok := cluster.Annotations[remediation.Annotation]; ok {
+		return c.handleRemediation(cluster, action)
+	}
+	if cluster.Spec.Import != nil {
+		err = c.handleClusterImport(cluster)
 	} else {
 		err = c.handleClusterAdd(cluster)
 	}
 	if err != nil {
-		c.syncQueue.Requeue(key, err)
+		return err
+	}
+	if types.ClusterConditionStalled.IsTrue(cluster) {
+		_, err = clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+			types.ClusterConditionStalled.False(toUpdate)
+			types.ClusterConditionStalled.Reason(toUpdate, "")
+			types.ClusterConditionStalled.Message(toUpdate, "")
+		})
+	}
+	return err
+}
+
+// park marks the cluster Stalled once the queue gave up retrying it
+func (c *Controller) park(key string, err error) {
+	cluster, getErr := c.clusterLister.Get(key)
+	if getErr != nil {
 		return
 	}
+	message := fmt.Sprintf("Gave up after %d retries, update the cluster to retry: %v", maxRetries, err)
+	c.recorder.Event(cluster, v1.EventTypeWarning, reasonRetriesExhausted, message)
+	_, updateErr := clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		types.ClusterConditionStalled.True(toUpdate)
+		types.ClusterConditionStalled.Reason(toUpdate, reasonRetriesExhausted)
+		types.ClusterConditionStalled.Message(toUpdate, message)
+	})
+	if updateErr != nil {
+		logrus.Errorf("Failed to mark cluster %s stalled %v", key, updateErr)
+	}
 }
 
 func (c *Controller) handleClusterRemove(cluster *types.Cluster) error {
+	if isProtected(cluster) && containsString(cluster.Finalizers, c.getName()) {
+		if c.setBlocked(cluster.Name, true) {
+			logrus.Infof("Cluster [%s] has the %s annotation, keeping it until it is cleared", cluster.Name, ProtectionAnnotation)
+			c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonDeletionBlocked,
+				"Deletion is blocked, remove the %s annotation to proceed", ProtectionAnnotation)
+		}
+		return nil
+	}
+	c.setBlocked(cluster.Name, false)
 	logrus.Infof("Removing cluster %v", cluster.Name)
 	if err := c.finalize(cluster, c.getName()); err != nil {
 		return fmt.Errorf("error removing cluster %s %v", cluster.Name, err)
@@ -83,87 +253,276 @@ func (c *Controller) handleClusterRemove(cluster *types.Cluster) error {
 	return nil
 }
 
+// setBlocked records whether the deletion of the cluster is blocked, and
+// tells whether that changed
+func (c *Controller) setBlocked(name string, blocked bool) bool {
+	c.blockedLock.Lock()
+	defer c.blockedLock.Unlock()
+	if c.blocked[name] == blocked {
+		return false
+	}
+	if blocked {
+		c.blocked[name] = true
+	} else {
+		delete(c.blocked, name)
+	}
+	return true
+}
+
 func (c *Controller) handleClusterAdd(cluster *types.Cluster) error {
-	config, err := getConfigStr(cluster)
+	config, err := rkeconfig.Render(cluster)
 	if err != nil {
 		return err
 	}
-	// Compare applied vs current config, and only run update when there are changes
-	if config == cluster.Status.AppliedConfig {
+	if revision, ok := cluster.Annotations[RollbackAnnotation]; ok {
+		return c.handleRollbackRequest(cluster, config, revision)
+	}
+	keys, err := sshkeys.Hash(c.getSecret, cluster)
+	if err != nil {
+		return err
+	}
+	// Compare applied vs current rendered config and keys, and only run update when there are changes
+	if config == cluster.Status.AppliedConfig && keys == cluster.Status.AppliedSSHKeys {
+		return nil
+	}
+	if plan.Hash(config) == cluster.Status.HeldConfigHash {
+		logrus.Debugf("Cluster [%s] was rolled back from its desired config, waiting for a spec change", cluster.Name)
+		return nil
+	}
+
+	changes := plan.ForCluster(cluster, config, keys)
+	approved := plan.Approved(cluster, changes)
+	if cluster, err = c.recordPlan(cluster, changes, approved); err != nil {
+		return fmt.Errorf("error updating plan of cluster %s %v", cluster.Name, err)
+	}
+	if !approved {
+		logrus.Infof("Cluster [%s] has disruptive changes waiting for approval", cluster.Name)
 		return nil
 	}
+	if rollbackDue(cluster) {
+		return c.rollback(cluster, config, types.ConfigRevision{
+			Revision: cluster.Status.Revision,
+			Config:   cluster.Status.AppliedConfig,
+		})
+	}
 
 	logrus.Infof("Cluster [%s] is updated; provisioning...", cluster.Name)
 	// Add finalizer and other init fields
-	if err := c.initialize(cluster, c.getName()); err != nil {
+	initialized, err := c.initialize(cluster, c.getName())
+	if err != nil {
 		return fmt.Errorf("error initializing cluster %s %v", cluster.Name, err)
 	}
+	cluster = initialized
 
 	// Provision the cluster
-	_, err = types.ClusterConditionProvisioned.Do(cluster, func() (runtime.Object, error) {
+	c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonProvisioning, "Provisioning cluster with %s", backends.Name(cluster))
+	toUpdate := cluster.DeepCopy()
+	_, provisionErr := types.ClusterConditionProvisioned.Do(toUpdate, func() (runtime.Object, error) {
 		// this is the place where cluster provisioning backend logic is being invoked
-		return cluster, provisionCluster(cluster)
+		return toUpdate, c.withRun(cluster, func(ctx context.Context) error {
+			return c.provisionCluster(ctx, toUpdate)
+		})
 	})
 
-	if err != nil {
-		return fmt.Errorf("error provisioning cluster %s %v", cluster.Name, err)
-	}
-	// Update cluster with applied spec
-	if err := c.updateAppliedConfig(cluster, config); err != nil {
+	// Update cluster status with the provisioning result and applied spec
+	if err := c.updateStatus(cluster, toUpdate, config, keys, provisionErr == nil); err != nil {
 		return fmt.Errorf("error updating cluster %s %v", cluster.Name, err)
 	}
+	if provisionErr != nil {
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonProvisioningFailed, "Failed to provision cluster: %v", provisionErr)
+		return fmt.Errorf("error provisioning cluster %s %v", cluster.Name, provisionErr)
+	}
+	c.recorder.Event(cluster, v1.EventTypeNormal, reasonProvisioned, "Provisioned cluster")
 	logrus.Infof("Successfully provisioned cluster %v", cluster.Name)
 	return nil
 }
 
-func removeCluster(cluster *types.Cluster) (err error) {
-	cmdName := "/Users/alena/go/src/github.com/rancher/rke/rke"
-	cmdArgs := []string{"remove", "--force", "--config", cluster.Spec.ConfigPath}
-	return executeCommand(cmdName, cmdArgs)
-}
-
-func executeCommand(cmdName string, cmdArgs []string) (err error) {
-	cmd := exec.Command(cmdName, cmdArgs...)
-	var stdout io.ReadCloser
-	stdout, err = cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("error getting stdout from cmd '%v' %v", cmd, err)
+// withRun calls fn with a context that is done once the cluster timeout
+// passed or the run got cancelled, the error then carries the Timeout or
+// Cancelled reason
+func (c *Controller) withRun(cluster *types.Cluster, fn func(ctx context.Context) error) error {
+	timeout := c.timeout
+	if cluster.Spec.Timeout != nil {
+		timeout = cluster.Spec.Timeout.Duration
 	}
-	if err = cmd.Start(); err != nil {
-		return fmt.Errorf("error starting cmd '%v' %v", cmd, err)
+	ctx, cancel := context.WithCancel(c.workCtx)
+	defer cancel()
+	runCtx := ctx
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		runCtx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
 	}
+
+	r := &run{cancel: cancel}
+	c.runningLock.Lock()
+	c.running[cluster.Name] = r
+	c.runningLock.Unlock()
 	defer func() {
-		err = cmd.Wait()
+		c.runningLock.Lock()
+		delete(c.running, cluster.Name)
+		c.runningLock.Unlock()
 	}()
-	printLogs(stdout)
+
+	err := fn(runCtx)
+	if err == nil {
+		return nil
+	}
+	c.runningLock.Lock()
+	cancelled := r.cancelled
+	c.runningLock.Unlock()
+	if cancelled {
+		return condition.Error(reasonCancelled, fmt.Errorf("cancelled by the %s annotation: %v", CancelAnnotation, err))
+	}
+	if runCtx.Err() == context.DeadlineExceeded {
+		return condition.Error(reasonTimeout, fmt.Errorf("timed out after %v: %v", timeout, err))
+	}
 	return err
 }
 
-func provisionCluster(cluster *types.Cluster) (err error) {
-	cmdName := "/Users/alena/go/src/github.com/rancher/rke/rke"
-	cmdArgs := []string{"up", "--config", cluster.Spec.ConfigPath}
-	return executeCommand(cmdName, cmdArgs)
+// cancel aborts the in-flight run of the cluster, if any
+func (c *Controller) cancel(cluster *types.Cluster) {
+	c.runningLock.Lock()
+	defer c.runningLock.Unlock()
+	r, ok := c.running[cluster.Name]
+	if !ok || r.cancelled {
+		return
+	}
+	r.cancelled = true
+	r.cancel()
+	logrus.Infof("Cancelling the provisioner run of cluster [%s]", cluster.Name)
+	c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonCancelled, "Cancelling the provisioner run, remove the %s annotation to resume", CancelAnnotation)
+}
+
+func (c *Controller) getSecret(name string) (*v1.Secret, error) {
+	return c.secretLister.Secrets(c.namespace).Get(name)
 }
 
-func getConfigStr(cluster *types.Cluster) (string, error) {
-	b, err := ioutil.ReadFile(cluster.Spec.ConfigPath)
+// enqueueSecretUsers enqueues the clusters taking their SSH key or imported
+// kubeconfig from the secret
+func (c *Controller) enqueueSecretUsers(obj interface{}) {
+	secret, ok := obj.(*v1.Secret)
+	if !ok {
+		return
+	}
+	clusters, err := c.clusterLister.List(labels.Everything())
 	if err != nil {
-		return "", err
+		logrus.Errorf("Failed to list clusters %v", err)
+		return
+	}
+	for _, cluster := range clusters {
+		if containsString(sshkeys.SecretNames(cluster), secret.Name) ||
+			(cluster.Spec.Import != nil && cluster.Spec.Import.KubeconfigSecret == secret.Name) {
+			c.syncQueue.Unpark(cluster)
+			c.syncQueue.Enqueue(cluster)
+		}
 	}
-	return string(b), nil
 }
 
-func printLogs(r io.Reader) {
-	buf := make([]byte, 80)
-	for {
-		n, err := r.Read(buf)
-		if n > 0 {
-			fmt.Print(string(buf[0:n]))
+// syncAnnotations are the annotations the sync of a cluster depends on
+var syncAnnotations = []string{
+	CancelAnnotation,
+	ProtectionAnnotation,
+	RollbackAnnotation,
+	remediation.Annotation,
+	plan.ApprovedAnnotation,
+}
+
+// needsSync tells whether the update of a cluster may change the outcome of
+// its sync
+func needsSync(old, cur *types.Cluster) bool {
+	if old.Generation != cur.Generation ||
+		(old.DeletionTimestamp == nil) != (cur.DeletionTimestamp == nil) {
+		return true
+	}
+	for _, annotation := range syncAnnotations {
+		oldValue, oldOk := old.Annotations[annotation]
+		curValue, curOk := cur.Annotations[annotation]
+		if oldOk != curOk || oldValue != curValue {
+			return true
 		}
-		if err != nil {
-			break
+	}
+	return false
+}
+
+// releaseSecrets removes the owner references of the cluster from the rke
+// state and kubeconfig secrets, so that they aren't garbage collected with it
+func (c *Controller) releaseSecrets(cluster *types.Cluster) error {
+	secrets := c.kubeClient.CoreV1().Secrets(c.namespace)
+	for _, name := range []string{rkestate.SecretName(cluster.Name), kubeconfigutil.SecretName(cluster.Name)} {
+		secret, err := secrets.Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		var owners []metav1.OwnerReference
+		for _, owner := range secret.OwnerReferences {
+			if owner.UID != cluster.UID {
+				owners = append(owners, owner)
+			}
 		}
+		if len(owners) == len(secret.OwnerReferences) {
+			continue
+		}
+		toUpdate := secret.DeepCopy()
+		toUpdate.OwnerReferences = owners
+		if _, err := secrets.Update(toUpdate); err != nil {
+			return err
+		}
+		logrus.Infof("Released secret %s/%s of cluster %s", c.namespace, name, cluster.Name)
 	}
+	return nil
+}
+
+func isProtected(cluster *types.Cluster) bool {
+	_, ok := cluster.Annotations[ProtectionAnnotation]
+	return ok
+}
+
+func isCancelled(cluster *types.Cluster) bool {
+	_, ok := cluster.Annotations[CancelAnnotation]
+	return ok
+}
+
+func removeCluster(ctx context.Context, cluster *types.Cluster) error {
+	backend, err := backends.ForCluster(cluster)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = backend.Remove(ctx, cluster)
+	metrics.ObserveProvisioning(cluster.Name, metrics.OperationRemove, start, err)
+	return err
+}
+
+// provisionCluster brings the cluster up and stores the kubeconfig it got
+func (c *Controller) provisionCluster(ctx context.Context, cluster *types.Cluster) error {
+	backend, err := backends.ForCluster(cluster)
+	if err != nil {
+		return err
+	}
+	if err := backend.Validate(cluster); err != nil {
+		return err
+	}
+	start := time.Now()
+	err = backend.Up(ctx, cluster)
+	metrics.ObserveProvisioning(cluster.Name, metrics.OperationUp, start, err)
+	if err != nil {
+		return err
+	}
+	return c.storeKubeconfig(cluster)
+}
+
+func restartControlPlane(ctx context.Context, cluster *types.Cluster) error {
+	backend, err := backends.ForCluster(cluster)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = backend.RestartControlPlane(ctx, cluster)
+	metrics.ObserveProvisioning(cluster.Name, metrics.OperationRestartControlPlane, start, err)
+	return err
 }
 
 func containsString(slice []string, item string) bool {
@@ -175,82 +534,121 @@ func containsString(slice []string, item string) bool {
 	return false
 }
 
-func (c *Controller) initialize(cluster *types.Cluster, finalizerKey string) error {
+func (c *Controller) initialize(cluster *types.Cluster, finalizerKey string) (*types.Cluster, error) {
 	//set finalizers
-	metadata, err := meta.Accessor(cluster)
-	if err != nil {
-		return err
-	}
-	if containsString(metadata.GetFinalizers(), finalizerKey) {
-		return nil
+	if containsString(cluster.Finalizers, finalizerKey) {
+		return cluster, nil
 	}
-	finalizers := metadata.GetFinalizers()
-	finalizers = append(finalizers, finalizerKey)
-	metadata.SetFinalizers(finalizers)
-	for i := 0; i < 3; i++ {
-		_, err = c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Update(cluster)
-		if err == nil {
-			return err
+	return clusterutil.Update(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		if !containsString(toUpdate.Finalizers, finalizerKey) {
+			toUpdate.Finalizers = append(toUpdate.Finalizers, finalizerKey)
 		}
-	}
-	return nil
+	})
 }
 
-func (c *Controller) updateAppliedConfig(cluster *types.Cluster, config string) error {
-	cluster.Status.AppliedConfig = config
-	for i := 0; i < 3; i++ {
-		_, err := c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Update(cluster)
-		if err == nil {
-			return nil
+// updateStatus writes the Provisioned condition computed on provisioned, and
+// the applied config and keys when provisioning succeeded
+func (c *Controller) updateStatus(cluster, provisioned *types.Cluster, config, keys string, applied bool) error {
+	_, err := clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		clusterutil.CopyCondition(toUpdate, provisioned, types.ClusterConditionProvisioned)
+		if !applied {
+			toUpdate.Status.FailedAttempts++
+			return
 		}
-	}
-	return nil
+		recordRevision(toUpdate, config)
+		toUpdate.Status.AppliedSSHKeys = keys
+		toUpdate.Status.Plan = nil
+		toUpdate.Status.FailedAttempts = 0
+		toUpdate.Status.HeldConfigHash = ""
+		if types.ClusterConditionRolledBack.GetReason(toUpdate) != "" {
+			types.ClusterConditionRolledBack.False(toUpdate)
+			types.ClusterConditionRolledBack.Reason(toUpdate, "")
+			types.ClusterConditionRolledBack.Message(toUpdate, "")
+		}
+	})
+	return err
 }
 
-func (c *Controller) finalize(cluster *types.Cluster, finalizerKey string) error {
-	toUpdate, err := c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Get(cluster.Name, v1.GetOptions{})
-	if err != nil {
-		if apierrors.IsNotFound(err) {
-			return nil
-		}
-		return err
+// recordPlan stores the plan on the cluster status, with the AwaitingApproval
+// condition reflecting whether it may run
+func (c *Controller) recordPlan(cluster *types.Cluster, changes *types.ClusterPlan, approved bool) (*types.Cluster, error) {
+	if cluster.Status.Plan == nil || cluster.Status.Plan.ConfigHash != changes.ConfigHash {
+		c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonPlanned, "Planned config %s: %s", changes.ConfigHash, plan.Summary(changes))
+	}
+	if !approved && !types.ClusterConditionAwaitingApproval.IsTrue(cluster) {
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonApprovalRequired,
+			"Disruptive changes wait for approval, set the %s annotation to %s", plan.ApprovedAnnotation, changes.ConfigHash)
 	}
-	metadata, err := meta.Accessor(toUpdate)
+	updated, err := clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		if toUpdate.Status.Plan == nil || toUpdate.Status.Plan.ConfigHash != changes.ConfigHash {
+			toUpdate.Status.FailedAttempts = 0
+		}
+		toUpdate.Status.Plan = changes
+		if !approved {
+			types.ClusterConditionAwaitingApproval.True(toUpdate)
+			types.ClusterConditionAwaitingApproval.Reason(toUpdate, reasonApprovalRequired)
+			types.ClusterConditionAwaitingApproval.Message(toUpdate,
+				fmt.Sprintf("Set the %s annotation to %s to apply: %s", plan.ApprovedAnnotation, changes.ConfigHash, plan.Summary(changes)))
+		} else if types.ClusterConditionAwaitingApproval.IsTrue(toUpdate) {
+			types.ClusterConditionAwaitingApproval.False(toUpdate)
+			types.ClusterConditionAwaitingApproval.Reason(toUpdate, "")
+			types.ClusterConditionAwaitingApproval.Message(toUpdate, "")
+		}
+	})
 	if err != nil {
-		return err
+		return cluster, err
 	}
+	return updated, nil
+}
+
+func (c *Controller) finalize(cluster *types.Cluster, finalizerKey string) error {
 	// Check finalizer
-	if metadata.GetDeletionTimestamp() == nil {
-		// already deleted
+	if cluster.DeletionTimestamp == nil {
+		// not being deleted
 		return nil
 	}
 
-	// already "finalized" by this controllerß
-	if !containsString(metadata.GetFinalizers(), finalizerKey) {
+	// already "finalized" by this controller
+	if !containsString(cluster.Finalizers, finalizerKey) {
 		return nil
 	}
 
 	//run deletion hook - call cluster cleanup logic on the backend
-	err = removeCluster(cluster)
-	if err != nil {
-		return err
-	}
-	// remove finalizer when/if the cleanup passed successfully
-	var finalizers []string
-	for _, finalizer := range metadata.GetFinalizers() {
-		if finalizer == finalizerKey {
-			continue
+	// imported clusters are left running, they may have been provisioned
+	// before being imported
+	var err error
+	if cluster.Spec.Import != nil || cluster.Spec.DeletionPolicy == types.DeletionPolicyOrphan {
+		// the state and kubeconfig must outlive the resource, for the
+		// cluster to be adopted again
+		if err := c.releaseSecrets(cluster); err != nil {
+			return fmt.Errorf("error releasing secrets %v", err)
+		}
+		c.recorder.Event(cluster, v1.EventTypeNormal, reasonOrphaned, "Leaving the cluster running")
+	} else {
+		c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonCleanupStarted, "Removing cluster with %s", backends.Name(cluster))
+		err = c.withRun(cluster, func(ctx context.Context) error {
+			return removeCluster(ctx, cluster)
+		})
+		if err != nil {
+			c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonCleanupFailed, "Failed to remove cluster: %v", err)
+			return err
 		}
-		finalizers = append(finalizers, finalizer)
 	}
-	metadata.SetFinalizers(finalizers)
-
-	for i := 0; i < 3; i++ {
-		_, err = c.clusterClient.ClusterprovisionerV1alpha1().Clusters().Update(toUpdate)
-		if err == nil {
-			break
+	// remove finalizer when/if the cleanup passed successfully
+	_, err = clusterutil.Update(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		var finalizers []string
+		for _, finalizer := range toUpdate.Finalizers {
+			if finalizer == finalizerKey {
+				continue
+			}
+			finalizers = append(finalizers, finalizer)
 		}
+		toUpdate.Finalizers = finalizers
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
 	}
-
-	return err
+	c.recorder.Event(cluster, v1.EventTypeNormal, reasonCleanupFinished, "Removed cluster")
+	metrics.ForgetCluster(cluster.Name)
+	return nil
 }
@@ -0,0 +1,700 @@
+package provisioner
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	clusterfake "github.com/rancher/kubecon2018/pkg/client/clientset/versioned/fake"
+	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/clusterutil/clustertest"
+	kubeconfigutil "github.com/rancher/kubecon2018/pkg/kubeconfig"
+	"github.com/rancher/kubecon2018/pkg/plan"
+	backends "github.com/rancher/kubecon2018/pkg/provisioner"
+	"github.com/rancher/kubecon2018/pkg/provisioner/fake"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+	"github.com/rancher/kubecon2018/pkg/rkestate"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	testNamespace = "cluster-provisioner"
+
+	importedKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: imported
+  cluster:
+    server: https://imported.invalid:6443
+contexts:
+- name: imported
+  context:
+    cluster: imported
+    user: admin
+current-context: imported
+users:
+- name: admin
+  user:
+    token: imported
+`
+)
+
+// testController is a provisioner controller backed by fake clientsets and
+// the fake provisioner, its informer caches are filled by hand
+type testController struct {
+	*Controller
+	client     *clusterfake.Clientset
+	kubeClient *kubefake.Clientset
+	backend    *fake.Provisioner
+	recorder   *record.FakeRecorder
+	clusters   cache.Indexer
+	secrets    cache.Indexer
+}
+
+// syncStep updates the stored cluster, if update is set, and syncs it
+type syncStep struct {
+	update  func(cluster *types.Cluster)
+	wantErr bool
+}
+
+func newTestController(t *testing.T, cluster *types.Cluster, objects []runtime.Object) *testController {
+	client, err := clustertest.NewClientset(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeClient := kubefake.NewSimpleClientset(objects...)
+	factory := informers.NewSharedInformerFactory(client, 0)
+	secretInformer := cache.NewSharedIndexInformer(&cache.ListWatch{}, &v1.Secret{}, 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	backend := fake.NewProvisioner()
+	backends.Register(fake.Name, backend)
+
+	recorder := record.NewFakeRecorder(1000)
+	controller := Register(context.Background(), client, kubeClient, factory, secretInformer,
+		recorder, testNamespace, context.Background(), time.Minute)
+	c := &testController{
+		Controller: controller,
+		client:     client,
+		kubeClient: kubeClient,
+		backend:    backend,
+		recorder:   recorder,
+		clusters:   factory.Clusterprovisioner().V1alpha1().Clusters().Informer().GetIndexer(),
+		secrets:    secretInformer.GetIndexer(),
+	}
+	c.refresh(t, cluster.Name)
+	return c
+}
+
+// refresh fills the caches with the stored objects and returns the cluster
+func (c *testController) refresh(t *testing.T, name string) *types.Cluster {
+	cluster, err := c.client.ClusterprovisionerV1alpha1().Clusters().Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.clusters.Add(cluster); err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := c.kubeClient.CoreV1().Secrets(testNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objs []interface{}
+	for i := range secrets.Items {
+		objs = append(objs, &secrets.Items[i])
+	}
+	if err := c.secrets.Replace(objs, ""); err != nil {
+		t.Fatal(err)
+	}
+	return cluster
+}
+
+// run runs the steps on the cluster, and returns the stored cluster
+func (c *testController) run(t *testing.T, name string, steps []syncStep) *types.Cluster {
+	for i, step := range steps {
+		if step.update != nil {
+			cluster := c.refresh(t, name).DeepCopy()
+			step.update(cluster)
+			if _, err := c.client.ClusterprovisionerV1alpha1().Clusters().Update(cluster); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c.refresh(t, name)
+		err := c.sync(name)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("step %d: sync error %v, want error %v", i, err, step.wantErr)
+		}
+	}
+	return c.refresh(t, name)
+}
+
+func newCluster(name string) *types.Cluster {
+	return &types.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			UID:        apitypes.UID("uid-" + name),
+			Generation: 1,
+		},
+		Spec: types.ClusterSpec{
+			Provisioner: fake.Name,
+			RKEConfig: &types.RKEConfig{
+				Nodes: []types.RKEConfigNode{
+					{Address: "10.0.0.1", User: "ubuntu", Role: []string{"etcd", "controlplane", "worker"}},
+				},
+				KubernetesVersion: "v1.10.1",
+			},
+		},
+	}
+}
+
+// reasons returns the reasons of the events recorded so far
+func (c *testController) reasons() map[string]bool {
+	reasons := map[string]bool{}
+	for {
+		select {
+		case event := <-c.recorder.Events:
+			// events are formatted as "<type> <reason> <message>"
+			fields := strings.SplitN(event, " ", 3)
+			if len(fields) > 1 {
+				reasons[fields[1]] = true
+			}
+		default:
+			return reasons
+		}
+	}
+}
+
+// secret returns the stored secret of the test namespace
+func (c *testController) secret(t *testing.T, name string) *v1.Secret {
+	secret, err := c.kubeClient.CoreV1().Secrets(testNamespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func sshKeySecret(name, key string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Data:       map[string][]byte{v1.SSHAuthPrivateKey: []byte(key)},
+	}
+}
+
+func setVersion(version string) func(*types.Cluster) {
+	return func(cluster *types.Cluster) {
+		cluster.Spec.RKEConfig.KubernetesVersion = version
+	}
+}
+
+// setAnnotation sets the annotation, or removes it when value is empty
+func setAnnotation(key, value string) func(*types.Cluster) {
+	return func(cluster *types.Cluster) {
+		if value == "" {
+			delete(cluster.Annotations, key)
+			return
+		}
+		if cluster.Annotations == nil {
+			cluster.Annotations = map[string]string{}
+		}
+		cluster.Annotations[key] = value
+	}
+}
+
+func deleteCluster(cluster *types.Cluster) {
+	now := metav1.Now()
+	cluster.DeletionTimestamp = &now
+}
+
+func renderConfig(t *testing.T, cluster *types.Cluster) string {
+	config, err := rkeconfig.Render(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func newImportedCluster(name string) *types.Cluster {
+	return &types.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			UID:        apitypes.UID("uid-" + name),
+			Generation: 1,
+		},
+		Spec: types.ClusterSpec{
+			Provisioner: fake.Name,
+			Import:      &types.ImportSpec{KubeconfigSecret: name + "-import"},
+		},
+	}
+}
+
+// importSecret returns the secret holding the kubeconfig of an imported
+// cluster
+func importSecret(cluster *types.Cluster, kubeconfig string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: cluster.Spec.Import.KubeconfigSecret, Namespace: testNamespace},
+		Data:       map[string][]byte{types.KubeconfigSecretKey: []byte(kubeconfig)},
+	}
+}
+
+// steps repeats step n times
+func steps(n int, step syncStep) []syncStep {
+	var result []syncStep
+	for i := 0; i < n; i++ {
+		result = append(result, step)
+	}
+	return result
+}
+
+func TestSync(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster func() *types.Cluster
+		objects []runtime.Object
+		steps   []syncStep
+		check   func(t *testing.T, c *testController, cluster *types.Cluster)
+	}{
+		{
+			name:    "provisions with the fake backend once",
+			cluster: func() *types.Cluster { return newCluster("test") },
+			steps:   steps(2, syncStep{}),
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 1 {
+					t.Errorf("provisioned %d times, want 1", n)
+				}
+				if !types.ClusterConditionProvisioned.IsTrue(cluster) {
+					t.Errorf("cluster isn't Provisioned: %+v", cluster.Status.Conditions)
+				}
+				if !containsString(cluster.Finalizers, c.getName()) {
+					t.Errorf("finalizer missing: %v", cluster.Finalizers)
+				}
+			},
+		},
+		{
+			name: "fails validation",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("invalid")
+				cluster.Spec.RKEConfig.Nodes[0].Role = []string{"master"}
+				return cluster
+			},
+			steps: []syncStep{{wantErr: true}},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 0 {
+					t.Errorf("provisioned %d times, want 0", n)
+				}
+				if types.ClusterConditionProvisioned.IsTrue(cluster) {
+					t.Errorf("invalid cluster is Provisioned: %+v", cluster.Status.Conditions)
+				}
+			},
+		},
+		{
+			name: "fails on an unknown provisioner",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("unknown")
+				cluster.Spec.Provisioner = "unknown"
+				return cluster
+			},
+			steps: []syncStep{{wantErr: true}},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 0 {
+					t.Errorf("provisioned %d times, want 0", n)
+				}
+			},
+		},
+		{
+			name:    "stores the kubeconfig once provisioned",
+			cluster: func() *types.Cluster { return newCluster("kubeconfig") },
+			steps:   []syncStep{{}},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				secret := c.secret(t, kubeconfigutil.SecretName(cluster.Name))
+				if len(secret.Data[types.KubeconfigSecretKey]) == 0 {
+					t.Errorf("kubeconfig secret has no %s key", types.KubeconfigSecretKey)
+				}
+				if !clusterutil.IsOwner(cluster, secret.OwnerReferences) {
+					t.Errorf("kubeconfig secret isn't owned by the cluster: %+v", secret.OwnerReferences)
+				}
+			},
+		},
+		{
+			name:    "adopts a stale kubeconfig secret",
+			cluster: func() *types.Cluster { return newCluster("stale") },
+			objects: []runtime.Object{&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: kubeconfigutil.SecretName("stale"), Namespace: testNamespace},
+				Data:       map[string][]byte{types.KubeconfigSecretKey: []byte("stale")},
+			}},
+			steps: []syncStep{{}},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				secret := c.secret(t, kubeconfigutil.SecretName(cluster.Name))
+				if string(secret.Data[types.KubeconfigSecretKey]) == "stale" {
+					t.Error("stale kubeconfig was kept")
+				}
+				if !clusterutil.IsOwner(cluster, secret.OwnerReferences) {
+					t.Errorf("kubeconfig secret isn't owned by the cluster: %+v", secret.OwnerReferences)
+				}
+			},
+		},
+		{
+			name: "keeps SSH key secrets out of the applied config",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("keys")
+				cluster.Spec.RKEConfig.SSHKeySecret = "node-key"
+				return cluster
+			},
+			objects: []runtime.Object{sshKeySecret("node-key", "key")},
+			steps:   []syncStep{{}},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if strings.Contains(cluster.Status.AppliedConfig, "ssh_key_secret") {
+					t.Errorf("applied config has the SSH key secret:\n%s", cluster.Status.AppliedConfig)
+				}
+				if cluster.Status.AppliedSSHKeys == "" {
+					t.Error("applied SSH keys weren't recorded")
+				}
+			},
+		},
+		{
+			name: "holds disruptive changes until approved",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("approval")
+				cluster.Spec.RequireApproval = true
+				return cluster
+			},
+			steps: []syncStep{
+				{},
+				{update: func(cluster *types.Cluster) {
+					cluster.Spec.RKEConfig.KubernetesVersion = "v1.11.0"
+				}},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 1 {
+					t.Errorf("provisioned %d times, want 1", n)
+				}
+				if !types.ClusterConditionAwaitingApproval.IsTrue(cluster) {
+					t.Errorf("cluster isn't AwaitingApproval: %+v", cluster.Status.Conditions)
+				}
+				if cluster.Status.Plan == nil || !cluster.Status.Plan.Disruptive {
+					t.Errorf("disruptive plan wasn't recorded: %+v", cluster.Status.Plan)
+				}
+			},
+		},
+		{
+			name: "runs the approved plan",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("approved")
+				cluster.Spec.RequireApproval = true
+				return cluster
+			},
+			steps: []syncStep{
+				{},
+				{update: func(cluster *types.Cluster) {
+					cluster.Spec.RKEConfig.KubernetesVersion = "v1.11.0"
+				}},
+				{update: func(cluster *types.Cluster) {
+					cluster.Annotations = map[string]string{plan.ApprovedAnnotation: cluster.Status.Plan.ConfigHash}
+				}},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 2 {
+					t.Errorf("provisioned %d times, want 2", n)
+				}
+				if types.ClusterConditionAwaitingApproval.IsTrue(cluster) || cluster.Status.Plan != nil {
+					t.Errorf("approved plan is still pending: %+v", cluster.Status)
+				}
+			},
+		},
+		{
+			name: "reprovisions on an SSH key rotation",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("rotation")
+				cluster.Spec.RKEConfig.SSHKeySecret = "node-key"
+				return cluster
+			},
+			objects: []runtime.Object{sshKeySecret("node-key", "old")},
+			steps:   []syncStep{{}},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 1 {
+					t.Fatalf("provisioned %d times before the rotation, want 1", n)
+				}
+				if _, err := c.kubeClient.CoreV1().Secrets(testNamespace).Update(sshKeySecret("node-key", "new")); err != nil {
+					t.Fatal(err)
+				}
+				cluster = c.run(t, cluster.Name, []syncStep{{}})
+				if n := c.backend.UpCount(cluster.Name); n != 2 {
+					t.Errorf("provisioned %d times after the rotation, want 2", n)
+				}
+			},
+		},
+		{
+			name:    "records revisions and trims the history",
+			cluster: func() *types.Cluster { return newCluster("history") },
+			steps: []syncStep{
+				{},
+				{update: setVersion("v1.10.2")},
+				{update: setVersion("v1.10.3")},
+				{update: setVersion("v1.10.4")},
+				{update: setVersion("v1.10.5")},
+				{update: setVersion("v1.10.6")},
+				{update: setVersion("v1.10.7")},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 7 {
+					t.Errorf("provisioned %d times, want 7", n)
+				}
+				if cluster.Status.Revision != 7 || len(cluster.Status.History) != historyLimit {
+					t.Fatalf("revision %d with %d in history, want 7 with %d", cluster.Status.Revision, len(cluster.Status.History), historyLimit)
+				}
+				if first := cluster.Status.History[0].Revision; first != 3 {
+					t.Errorf("history starts at revision %d, want 3", first)
+				}
+				if last := cluster.Status.History[historyLimit-1]; last.Config != cluster.Status.AppliedConfig {
+					t.Errorf("last revision isn't the applied config:\n%s", last.Config)
+				}
+			},
+		},
+		{
+			name: "counts failed attempts",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("failing")
+				cluster.Annotations = map[string]string{fake.FailAnnotation: "up"}
+				return cluster
+			},
+			steps: steps(2, syncStep{wantErr: true}),
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if cluster.Status.FailedAttempts != 2 {
+					t.Errorf("recorded %d failed attempts, want 2", cluster.Status.FailedAttempts)
+				}
+				if cluster.Status.AppliedConfig != "" || cluster.Status.Revision != 0 || cluster.Status.Plan == nil {
+					t.Errorf("failed runs changed the applied state: %+v", cluster.Status)
+				}
+			},
+		},
+		{
+			name: "resets failed attempts once the change applied",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("recovering")
+				cluster.Annotations = map[string]string{fake.FailAnnotation: "up"}
+				return cluster
+			},
+			steps: []syncStep{
+				{wantErr: true},
+				{update: setAnnotation(fake.FailAnnotation, "")},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if cluster.Status.FailedAttempts != 0 || cluster.Status.Revision != 1 {
+					t.Errorf("unexpected status %+v", cluster.Status)
+				}
+			},
+		},
+		{
+			name:    "rolls back on request",
+			cluster: func() *types.Cluster { return newCluster("rollback") },
+			steps: []syncStep{
+				{},
+				{update: setVersion("v1.11.0")},
+				{update: setAnnotation(RollbackAnnotation, "1")},
+				{},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if _, ok := cluster.Annotations[RollbackAnnotation]; ok {
+					t.Error("rollback annotation wasn't consumed")
+				}
+				if n := c.backend.UpCount(cluster.Name); n != 3 {
+					t.Errorf("provisioned %d times, want 3", n)
+				}
+				if cluster.Status.Revision != 3 || cluster.Status.AppliedConfig != cluster.Status.History[0].Config {
+					t.Errorf("revision 1 wasn't restored as revision 3: %+v", cluster.Status)
+				}
+				if want := plan.Hash(renderConfig(t, cluster)); cluster.Status.HeldConfigHash != want {
+					t.Errorf("held config %q, want %q", cluster.Status.HeldConfigHash, want)
+				}
+				if !types.ClusterConditionRolledBack.IsTrue(cluster) {
+					t.Errorf("cluster isn't RolledBack: %+v", cluster.Status.Conditions)
+				}
+			},
+		},
+		{
+			name:    "rejects a rollback to an unknown revision",
+			cluster: func() *types.Cluster { return newCluster("unknown-revision") },
+			steps: []syncStep{
+				{},
+				{update: setAnnotation(RollbackAnnotation, "9")},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if _, ok := cluster.Annotations[RollbackAnnotation]; ok {
+					t.Error("rejected rollback annotation was kept")
+				}
+				if reason := types.ClusterConditionRolledBack.GetReason(cluster); reason != reasonRollbackFailed {
+					t.Errorf("RolledBack reason %q, want %s", reason, reasonRollbackFailed)
+				}
+				if n := c.backend.UpCount(cluster.Name); n != 1 {
+					t.Errorf("provisioned %d times, want 1", n)
+				}
+			},
+		},
+		{
+			name: "rolls back after the failures of the policy",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("auto-rollback")
+				cluster.Spec.Rollback = &types.RollbackPolicy{AfterFailures: 2}
+				return cluster
+			},
+			steps: []syncStep{
+				{},
+				{update: func(cluster *types.Cluster) {
+					setVersion("v1.11.0")(cluster)
+					setAnnotation(fake.FailAnnotation, "up")(cluster)
+				}, wantErr: true},
+				{wantErr: true},
+				// the failures are counted, the applied config comes back up
+				{update: setAnnotation(fake.FailAnnotation, "")},
+				{},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 2 {
+					t.Errorf("provisioned %d times, want 2", n)
+				}
+				if cluster.Status.Revision != 1 || cluster.Status.FailedAttempts != 0 {
+					t.Errorf("unexpected status %+v", cluster.Status)
+				}
+				if want := plan.Hash(renderConfig(t, cluster)); cluster.Status.HeldConfigHash != want {
+					t.Errorf("held config %q, want %q", cluster.Status.HeldConfigHash, want)
+				}
+				if !types.ClusterConditionRolledBack.IsTrue(cluster) {
+					t.Errorf("cluster isn't RolledBack: %+v", cluster.Status.Conditions)
+				}
+			},
+		},
+		{
+			name: "orphans the cluster and releases its secrets",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("orphan")
+				cluster.Spec.DeletionPolicy = types.DeletionPolicyOrphan
+				return cluster
+			},
+			objects: []runtime.Object{&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            rkestate.SecretName("orphan"),
+					Namespace:       testNamespace,
+					OwnerReferences: []metav1.OwnerReference{clusterutil.OwnerReference(newCluster("orphan"))},
+				},
+			}},
+			steps: []syncStep{
+				{},
+				{update: deleteCluster},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if clusters := c.backend.Clusters(); len(clusters) != 1 {
+					t.Errorf("orphaned cluster was removed, left %v", clusters)
+				}
+				for _, name := range []string{rkestate.SecretName(cluster.Name), kubeconfigutil.SecretName(cluster.Name)} {
+					if secret := c.secret(t, name); clusterutil.IsOwner(cluster, secret.OwnerReferences) {
+						t.Errorf("secret %s is still owned by the cluster", name)
+					}
+				}
+				if containsString(cluster.Finalizers, c.getName()) {
+					t.Errorf("finalizer wasn't removed: %v", cluster.Finalizers)
+				}
+			},
+		},
+		{
+			name: "blocks the deletion of a protected cluster",
+			cluster: func() *types.Cluster {
+				cluster := newCluster("protected")
+				cluster.Annotations = map[string]string{ProtectionAnnotation: "true"}
+				return cluster
+			},
+			steps: []syncStep{
+				{},
+				{update: deleteCluster},
+				{},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if clusters := c.backend.Clusters(); len(clusters) != 1 {
+					t.Errorf("protected cluster was removed, left %v", clusters)
+				}
+				if !containsString(cluster.Finalizers, c.getName()) {
+					t.Errorf("finalizer of the protected cluster was removed: %v", cluster.Finalizers)
+				}
+				// lifting the protection lets the deletion proceed
+				cluster = c.run(t, cluster.Name, []syncStep{{update: setAnnotation(ProtectionAnnotation, "")}})
+				if clusters := c.backend.Clusters(); len(clusters) != 0 {
+					t.Errorf("unprotected cluster wasn't removed, left %v", clusters)
+				}
+				if containsString(cluster.Finalizers, c.getName()) {
+					t.Errorf("finalizer wasn't removed: %v", cluster.Finalizers)
+				}
+			},
+		},
+		{
+			name:    "imports a cluster without provisioning it",
+			cluster: func() *types.Cluster { return newImportedCluster("imported") },
+			objects: []runtime.Object{importSecret(newImportedCluster("imported"), importedKubeconfig)},
+			steps:   steps(2, syncStep{}),
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if n := c.backend.UpCount(cluster.Name); n != 0 {
+					t.Errorf("imported cluster was provisioned %d times", n)
+				}
+				if !types.ClusterConditionProvisioned.IsTrue(cluster) {
+					t.Errorf("imported cluster isn't Provisioned: %+v", cluster.Status.Conditions)
+				}
+				if cluster.Status.AppliedConfig != "" || cluster.Status.Revision != 0 {
+					t.Errorf("imported cluster has an applied config: %+v", cluster.Status)
+				}
+				if _, err := c.kubeClient.CoreV1().Secrets(testNamespace).Get(kubeconfigutil.SecretName(cluster.Name), metav1.GetOptions{}); err == nil {
+					t.Error("kubeconfig of the imported cluster was copied")
+				}
+			},
+		},
+		{
+			name:    "fails the import of an invalid kubeconfig",
+			cluster: func() *types.Cluster { return newImportedCluster("invalid-import") },
+			objects: []runtime.Object{importSecret(newImportedCluster("invalid-import"), "invalid")},
+			steps:   []syncStep{{wantErr: true}},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				if types.ClusterConditionProvisioned.IsTrue(cluster) {
+					t.Errorf("invalid import is Provisioned: %+v", cluster.Status.Conditions)
+				}
+				if !c.reasons()[reasonImportFailed] {
+					t.Errorf("no %s event", reasonImportFailed)
+				}
+			},
+		},
+		{
+			name:    "leaves an imported cluster and its secret on delete",
+			cluster: func() *types.Cluster { return newImportedCluster("imported-delete") },
+			objects: []runtime.Object{importSecret(newImportedCluster("imported-delete"), importedKubeconfig)},
+			steps: []syncStep{
+				{},
+				{update: deleteCluster},
+			},
+			check: func(t *testing.T, c *testController, cluster *types.Cluster) {
+				reasons := c.reasons()
+				if reasons[reasonCleanupStarted] || !reasons[reasonOrphaned] {
+					t.Errorf("imported cluster wasn't orphaned, events %v", reasons)
+				}
+				if n := c.backend.UpCount(cluster.Name); n != 0 {
+					t.Errorf("imported cluster was provisioned %d times", n)
+				}
+				if containsString(cluster.Finalizers, c.getName()) {
+					t.Errorf("finalizer wasn't removed: %v", cluster.Finalizers)
+				}
+				if secret := c.secret(t, cluster.Spec.Import.KubeconfigSecret); len(secret.Data[types.KubeconfigSecretKey]) == 0 {
+					t.Error("import secret was changed")
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster := test.cluster()
+			c := newTestController(t, cluster, test.objects)
+			test.check(t, c, c.run(t, cluster.Name, test.steps))
+		})
+	}
+}
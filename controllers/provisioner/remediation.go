@@ -0,0 +1,116 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/remediation"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+	"github.com/rancher/kubecon2018/pkg/sshkeys"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+)
+
+const (
+	reasonRemediating       = "Remediating"
+	reasonRemediated        = "Remediated"
+	reasonRemediationFailed = "RemediationFailed"
+)
+
+// handleRemediation runs the action requested through the remediation
+// annotation, records its result on the pending attempt and removes the
+// annotation. A failed remediation isn't retried, the health checker
+// triggers the next one once the cooldown passed.
+func (c *Controller) handleRemediation(cluster *types.Cluster, value string) error {
+	action := types.RemediationAction(value)
+	if cluster.Spec.Import != nil {
+		c.recorder.Event(cluster, v1.EventTypeWarning, reasonRemediationFailed, "Imported clusters can't be remediated")
+		return c.clearRemediation(cluster)
+	}
+	// the health checker records the attempt before requesting it, the ones
+	// requested by hand are recorded here
+	started, err := clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		if pending := remediation.Pending(toUpdate); pending != nil && pending.Action == action {
+			return
+		}
+		remediation.Start(toUpdate, action, remediation.TriggerManual)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating cluster %s %v", cluster.Name, err)
+	}
+	cluster = started
+
+	logrus.Infof("Remediating cluster [%s] with %s", cluster.Name, action)
+	c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonRemediating, "Running %s remediation, triggered by %s",
+		action, remediation.Pending(cluster).Trigger)
+	remediationErr := c.remediate(cluster, action)
+
+	_, err = clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		remediation.Finish(toUpdate, remediationErr)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating cluster %s %v", cluster.Name, err)
+	}
+	if remediationErr != nil {
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonRemediationFailed, "%s remediation failed: %v", action, remediationErr)
+		logrus.Errorf("Failed to remediate cluster %s %v", cluster.Name, remediationErr)
+	} else {
+		c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonRemediated, "%s remediation succeeded", action)
+		logrus.Infof("Successfully remediated cluster %v", cluster.Name)
+	}
+	return c.clearRemediation(cluster)
+}
+
+func (c *Controller) remediate(cluster *types.Cluster, action types.RemediationAction) error {
+	switch action {
+	case types.RemediationActionReprovision:
+		target, err := appliedCluster(cluster)
+		if err != nil {
+			return err
+		}
+		return c.withRun(target, func(ctx context.Context) error {
+			return c.provisionCluster(ctx, target)
+		})
+	case types.RemediationActionRestartControlPlane:
+		return c.withRun(cluster, func(ctx context.Context) error {
+			return restartControlPlane(ctx, cluster)
+		})
+	}
+	return fmt.Errorf("unknown remediation action %q", action)
+}
+
+func (c *Controller) clearRemediation(cluster *types.Cluster) error {
+	_, err := clusterutil.Update(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		delete(toUpdate.Annotations, remediation.Annotation)
+	})
+	return err
+}
+
+// appliedCluster returns a copy of the cluster carrying its applied config,
+// so that reprovisioning doesn't apply the pending changes
+func appliedCluster(cluster *types.Cluster) (*types.Cluster, error) {
+	if cluster.Status.AppliedConfig == "" {
+		return nil, fmt.Errorf("cluster %s was never provisioned", cluster.Name)
+	}
+	target := cluster.DeepCopy()
+	if cluster.Spec.RKEConfig == nil {
+		// a legacy config file can't be swapped, it has to be the applied one
+		config, err := rkeconfig.Render(cluster)
+		if err != nil {
+			return nil, err
+		}
+		if config != cluster.Status.AppliedConfig {
+			return nil, fmt.Errorf("%s changed since it was applied", cluster.Spec.ConfigPath)
+		}
+		return target, nil
+	}
+	rkeConfig, err := rkeconfig.Parse(cluster.Status.AppliedConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error reading applied config of cluster %s %v", cluster.Name, err)
+	}
+	sshkeys.Inherit(rkeConfig, cluster.Spec.RKEConfig)
+	target.Spec.RKEConfig = rkeConfig
+	return target, nil
+}
@@ -0,0 +1,180 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/plan"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+	"github.com/rancher/kubecon2018/pkg/sshkeys"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// RollbackAnnotation requests the cluster to be rolled back to the
+	// revision it holds, it is removed once the rollback succeeded
+	RollbackAnnotation = "clusterprovisioner.rke.io/rollback-to"
+
+	// historyLimit is the number of applied configs kept in the status
+	historyLimit = 5
+
+	reasonRollingBack    = "RollingBack"
+	reasonRolledBack     = "RolledBack"
+	reasonRollbackFailed = "RollbackFailed"
+)
+
+// rollbackDue tells whether the planned change failed often enough for the
+// rollback policy of the cluster to restore the applied config
+func rollbackDue(cluster *types.Cluster) bool {
+	policy := cluster.Spec.Rollback
+	return policy != nil && policy.AfterFailures > 0 &&
+		cluster.Spec.RKEConfig != nil &&
+		cluster.Status.AppliedConfig != "" &&
+		cluster.Status.FailedAttempts >= policy.AfterFailures
+}
+
+// handleRollbackRequest restores the revision requested through the
+// RollbackAnnotation, then removes the annotation
+func (c *Controller) handleRollbackRequest(cluster *types.Cluster, desired, value string) error {
+	var revision *types.ConfigRevision
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+		revision = findRevision(cluster, number)
+	}
+	if revision == nil {
+		return c.rejectRollback(cluster, fmt.Sprintf("No revision %q to roll back to, available: %v", value, revisions(cluster)))
+	}
+	if cluster.Spec.RKEConfig == nil {
+		return c.rejectRollback(cluster, "Rollback is only supported for clusters with an inline rkeConfig")
+	}
+	if err := c.rollback(cluster, desired, *revision); err != nil {
+		return err
+	}
+	_, err := clusterutil.Update(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		delete(toUpdate.Annotations, RollbackAnnotation)
+	})
+	return err
+}
+
+// rejectRollback reports a rollback request that can't be honored on the
+// RolledBack condition, and removes it so that spec changes apply again
+func (c *Controller) rejectRollback(cluster *types.Cluster, message string) error {
+	c.recorder.Event(cluster, v1.EventTypeWarning, reasonRollbackFailed, message)
+	_, err := clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		if !types.ClusterConditionRolledBack.IsTrue(toUpdate) {
+			types.ClusterConditionRolledBack.False(toUpdate)
+		}
+		types.ClusterConditionRolledBack.Reason(toUpdate, reasonRollbackFailed)
+		types.ClusterConditionRolledBack.Message(toUpdate, message)
+	})
+	if err != nil {
+		return fmt.Errorf("error updating cluster %s %v", cluster.Name, err)
+	}
+	_, err = clusterutil.Update(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		delete(toUpdate.Annotations, RollbackAnnotation)
+	})
+	return err
+}
+
+// rollback applies the config of revision, and holds the desired config until
+// it changes
+func (c *Controller) rollback(cluster *types.Cluster, desired string, revision types.ConfigRevision) error {
+	rkeConfig, err := rkeconfig.Parse(revision.Config)
+	if err != nil {
+		return fmt.Errorf("error reading revision %d of cluster %s %v", revision.Revision, cluster.Name, err)
+	}
+	sshkeys.Inherit(rkeConfig, cluster.Spec.RKEConfig)
+	logrus.Infof("Rolling back cluster [%s] to revision %d", cluster.Name, revision.Revision)
+	c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonRollingBack, "Rolling back to revision %d", revision.Revision)
+
+	// the backends render the spec, so the revision is provisioned through a
+	// copy carrying its config
+	target := cluster.DeepCopy()
+	target.Spec.RKEConfig = rkeConfig
+	keys, err := sshkeys.Hash(c.getSecret, target)
+	if err != nil {
+		return err
+	}
+	_, rollbackErr := types.ClusterConditionProvisioned.Do(target, func() (runtime.Object, error) {
+		return target, c.withRun(target, func(ctx context.Context) error {
+			return c.provisionCluster(ctx, target)
+		})
+	})
+
+	held := ""
+	if revision.Config != desired {
+		held = plan.Hash(desired)
+	}
+	_, err = clusterutil.UpdateStatus(c.clusterClient, cluster, func(toUpdate *types.Cluster) {
+		clusterutil.CopyCondition(toUpdate, target, types.ClusterConditionProvisioned)
+		if rollbackErr != nil {
+			return
+		}
+		if toUpdate.Status.AppliedConfig != revision.Config {
+			recordRevision(toUpdate, revision.Config)
+		}
+		toUpdate.Status.AppliedSSHKeys = keys
+		toUpdate.Status.Plan = nil
+		toUpdate.Status.FailedAttempts = 0
+		toUpdate.Status.HeldConfigHash = held
+		if held == "" {
+			if types.ClusterConditionRolledBack.GetReason(toUpdate) != "" {
+				types.ClusterConditionRolledBack.False(toUpdate)
+				types.ClusterConditionRolledBack.Reason(toUpdate, "")
+				types.ClusterConditionRolledBack.Message(toUpdate, "")
+			}
+			return
+		}
+		types.ClusterConditionRolledBack.True(toUpdate)
+		types.ClusterConditionRolledBack.Reason(toUpdate, reasonRolledBack)
+		types.ClusterConditionRolledBack.Message(toUpdate,
+			fmt.Sprintf("Restored revision %d, config %s is held until the spec changes", revision.Revision, held))
+	})
+	if err != nil {
+		return fmt.Errorf("error updating cluster %s %v", cluster.Name, err)
+	}
+	if rollbackErr != nil {
+		c.recorder.Eventf(cluster, v1.EventTypeWarning, reasonRollbackFailed, "Failed to roll back to revision %d: %v", revision.Revision, rollbackErr)
+		return fmt.Errorf("error rolling back cluster %s %v", cluster.Name, rollbackErr)
+	}
+	c.recorder.Eventf(cluster, v1.EventTypeNormal, reasonRolledBack, "Rolled back to revision %d", revision.Revision)
+	logrus.Infof("Successfully rolled back cluster %v", cluster.Name)
+	return nil
+}
+
+// recordRevision makes config the applied config and adds it to the history
+func recordRevision(cluster *types.Cluster, config string) {
+	cluster.Status.AppliedConfig = config
+	cluster.Status.Revision++
+	cluster.Status.History = append(cluster.Status.History, types.ConfigRevision{
+		Revision:   cluster.Status.Revision,
+		ConfigHash: plan.Hash(config),
+		Config:     config,
+		AppliedAt:  metav1.Now(),
+	})
+	if len(cluster.Status.History) > historyLimit {
+		cluster.Status.History = cluster.Status.History[len(cluster.Status.History)-historyLimit:]
+	}
+}
+
+func findRevision(cluster *types.Cluster, number int64) *types.ConfigRevision {
+	for i := range cluster.Status.History {
+		if cluster.Status.History[i].Revision == number {
+			return &cluster.Status.History[i]
+		}
+	}
+	return nil
+}
+
+func revisions(cluster *types.Cluster) []int64 {
+	var numbers []int64
+	for _, revision := range cluster.Status.History {
+		numbers = append(numbers, revision.Revision)
+	}
+	return numbers
+}
@@ -1,19 +1,51 @@
 package main
 
 import (
-	"bytes"
-	"io/ioutil"
+	"context"
+	"net/http"
 	_ "net/http/pprof"
 	"os"
-	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"fmt"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rancher/kubecon2018/controllers"
+	"github.com/rancher/kubecon2018/controllers/healthchecker"
+	provisionercontroller "github.com/rancher/kubecon2018/controllers/provisioner"
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	clusterclient "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"github.com/rancher/kubecon2018/pkg/crd"
+	"github.com/rancher/kubecon2018/pkg/downstream"
+	"github.com/rancher/kubecon2018/pkg/plan"
+	"github.com/rancher/kubecon2018/pkg/provisioner"
+	"github.com/rancher/kubecon2018/pkg/provisioner/fake"
+	"github.com/rancher/kubecon2018/pkg/provisioner/rke"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+	"github.com/rancher/kubecon2018/pkg/rkestate"
+	"github.com/rancher/kubecon2018/pkg/runlog"
+	"github.com/rancher/kubecon2018/pkg/sshkeys"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	leaderElectionName  = "kubecon2018"
+	crdEstablishTimeout = time.Minute
 )
 
 var (
@@ -30,58 +62,574 @@ func main() {
 			EnvVar: "KUBECONFIG",
 			Value:  "/Users/alena/.kube/config",
 		},
+		cli.StringFlag{
+			Name:   "rke-path",
+			Usage:  "Path to the rke binary used by the rke provisioner",
+			EnvVar: "RKE_PATH",
+			Value:  "rke",
+		},
+		cli.IntFlag{
+			Name:   "rke-max-processes",
+			Usage:  "Maximum number of rke processes running at the same time",
+			EnvVar: "RKE_MAX_PROCESSES",
+			Value:  2,
+		},
+		cli.IntFlag{
+			Name:   "provisioner-workers",
+			Usage:  "Number of clusters provisioned or removed concurrently",
+			EnvVar: "PROVISIONER_WORKERS",
+			Value:  4,
+		},
+		cli.StringFlag{
+			Name:   "work-dir",
+			Usage:  "Directory rke runs in and keeps its output in, the rke state is stored in secrets",
+			EnvVar: "WORK_DIR",
+			Value:  "/var/lib/kubecon2018",
+		},
+		cli.IntFlag{
+			Name:   "rke-log-retention",
+			Usage:  "Number of rke runs whose output is kept per cluster",
+			EnvVar: "RKE_LOG_RETENTION",
+			Value:  10,
+		},
+		cli.StringFlag{
+			Name:   "namespace",
+			Usage:  "Namespace the operator stores cluster secrets in",
+			EnvVar: "NAMESPACE",
+			Value:  "kube-system",
+		},
+		cli.BoolTFlag{
+			Name:   "leader-elect",
+			Usage:  "Elect a leader among operator replicas, only the leader runs controllers",
+			EnvVar: "LEADER_ELECT",
+		},
+		cli.StringFlag{
+			Name:   "leader-elect-resource-lock",
+			Usage:  "Type of resource used as leader election lock: configmaps or endpoints",
+			EnvVar: "LEADER_ELECT_RESOURCE_LOCK",
+			Value:  resourcelock.ConfigMapsResourceLock,
+		},
+		cli.DurationFlag{
+			Name:   "leader-elect-lease-duration",
+			Usage:  "Duration non-leader replicas wait before trying to acquire a lease that wasn't renewed",
+			EnvVar: "LEADER_ELECT_LEASE_DURATION",
+			Value:  15 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:   "leader-elect-renew-deadline",
+			Usage:  "Duration the leader retries renewing its lease before giving up leadership",
+			EnvVar: "LEADER_ELECT_RENEW_DEADLINE",
+			Value:  10 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:   "leader-elect-retry-period",
+			Usage:  "Duration between attempts to acquire or renew the lease",
+			EnvVar: "LEADER_ELECT_RETRY_PERIOD",
+			Value:  2 * time.Second,
+		},
+		cli.BoolFlag{
+			Name:   "skip-crd-install",
+			Usage:  "Don't create or update the CRDs, they must be installed beforehand",
+			EnvVar: "SKIP_CRD_INSTALL",
+		},
+		cli.StringFlag{
+			Name:   "listen-address",
+			Usage:  "Address /metrics and /debug/pprof are served on, disabled when empty",
+			EnvVar: "LISTEN_ADDRESS",
+			Value:  ":8080",
+		},
+		cli.DurationFlag{
+			Name:   "shutdown-grace-period",
+			Usage:  "Duration in-flight rke commands are given to finish on shutdown before they are killed",
+			EnvVar: "SHUTDOWN_GRACE_PERIOD",
+			Value:  30 * time.Second,
+		},
+		cli.DurationFlag{
+			Name:   "provision-timeout",
+			Usage:  "Duration after which a provisioner run is killed, unless the cluster sets its own timeout",
+			EnvVar: "PROVISION_TIMEOUT",
+			Value:  time.Hour,
+		},
+		cli.DurationFlag{
+			Name:   "health-check-interval",
+			Usage:  "Interval between the health probes of a cluster, unless the cluster sets its own",
+			EnvVar: "HEALTH_CHECK_INTERVAL",
+			Value:  time.Minute,
+		},
+		cli.Float64Flag{
+			Name:   "health-check-jitter",
+			Usage:  "Maximum fraction of the health check interval randomly added to it, unless the cluster sets its own jitter",
+			EnvVar: "HEALTH_CHECK_JITTER",
+			Value:  0.2,
+		},
+		cli.DurationFlag{
+			Name:   "health-check-timeout",
+			Usage:  "Timeout of each health probe request, unless the cluster sets its own",
+			EnvVar: "HEALTH_CHECK_TIMEOUT",
+			Value:  10 * time.Second,
+		},
+		cli.IntFlag{
+			Name:   "max-remediations",
+			Usage:  "Number of unhealthy clusters remediated within the remediation window at most, 0 disables remediation actions",
+			EnvVar: "MAX_REMEDIATIONS",
+			Value:  3,
+		},
+		cli.DurationFlag{
+			Name:   "remediation-window",
+			Usage:  "Period the max-remediations cap applies to",
+			EnvVar: "REMEDIATION_WINDOW",
+			Value:  time.Hour,
+		},
+		cli.Float64Flag{
+			Name:   "downstream-qps",
+			Usage:  "Requests per second the operator makes to each provisioned cluster",
+			EnvVar: "DOWNSTREAM_QPS",
+			Value:  5,
+		},
+		cli.IntFlag{
+			Name:   "downstream-burst",
+			Usage:  "Burst of requests the operator makes to each provisioned cluster",
+			EnvVar: "DOWNSTREAM_BURST",
+			Value:  10,
+		},
+		cli.DurationFlag{
+			Name:   "downstream-timeout",
+			Usage:  "Timeout of the requests to the provisioned clusters, health probes use health-check-timeout",
+			EnvVar: "DOWNSTREAM_TIMEOUT",
+			Value:  30 * time.Second,
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
-		return run(c.String("kubeconfig"))
+		if c.Int("provisioner-workers") < 1 {
+			return fmt.Errorf("provisioner-workers must be at least 1")
+		}
+		if c.Duration("health-check-interval") <= 0 {
+			return fmt.Errorf("health-check-interval must be positive")
+		}
+		if c.Float64("downstream-qps") <= 0 || c.Int("downstream-burst") < 1 {
+			return fmt.Errorf("downstream-qps and downstream-burst must be positive")
+		}
+		ctx := signalContext()
+		if address := c.String("listen-address"); address != "" {
+			serveHTTP(ctx, address)
+		}
+		return run(ctx, c.String("kubeconfig"), c.Bool("skip-crd-install"), controllers.Options{
+			Namespace:          c.String("namespace"),
+			GracePeriod:        c.Duration("shutdown-grace-period"),
+			ProvisionerWorkers: c.Int("provisioner-workers"),
+			ProvisionTimeout:   c.Duration("provision-timeout"),
+			HealthCheck: healthchecker.Options{
+				Interval:          c.Duration("health-check-interval"),
+				Jitter:            c.Float64("health-check-jitter"),
+				Timeout:           c.Duration("health-check-timeout"),
+				MaxRemediations:   c.Int("max-remediations"),
+				RemediationWindow: c.Duration("remediation-window"),
+			},
+			Downstream: downstream.Options{
+				QPS:     float32(c.Float64("downstream-qps")),
+				Burst:   c.Int("downstream-burst"),
+				Timeout: c.Duration("downstream-timeout"),
+			},
+		}, leaderElectionConfig{
+			enabled:       c.BoolT("leader-elect"),
+			resourceLock:  c.String("leader-elect-resource-lock"),
+			leaseDuration: c.Duration("leader-elect-lease-duration"),
+			renewDeadline: c.Duration("leader-elect-renew-deadline"),
+			retryPeriod:   c.Duration("leader-elect-retry-period"),
+		}, rkeOptions{
+			path:         c.String("rke-path"),
+			workDir:      c.String("work-dir"),
+			maxProcesses: c.Int("rke-max-processes"),
+			logs:         runlog.NewStore(c.String("work-dir"), c.Int("rke-log-retention")),
+		})
 	}
 
-	app.Run(os.Args)
+	app.Commands = []cli.Command{
+		{
+			Name:      "logs",
+			Usage:     "Print the rke output of a cluster",
+			ArgsUsage: "<cluster>",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "attempt",
+					Usage: "Attempt to print, the latest when not set",
+				},
+				cli.BoolFlag{
+					Name:  "follow, f",
+					Usage: "Keep printing the output until the attempt finished",
+				},
+			},
+			Action: logsCommand,
+		},
+		{
+			Name:      "plan",
+			Usage:     "Print the changes the next provisioner run makes to a cluster",
+			ArgsUsage: "<cluster>",
+			Action:    planCommand,
+		},
+		{
+			Name:      "rollback",
+			Usage:     "Roll a cluster back to a config from its history",
+			ArgsUsage: "<cluster>",
+			Flags: []cli.Flag{
+				cli.Int64Flag{
+					Name:  "revision",
+					Usage: "Revision to restore, the one before the applied revision when not set",
+				},
+				cli.BoolFlag{
+					Name:  "list",
+					Usage: "Print the revisions in the history instead",
+				},
+			},
+			Action: rollbackCommand,
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		logrus.Fatal(err)
+	}
+}
+
+type leaderElectionConfig struct {
+	enabled       bool
+	resourceLock  string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
 }
 
-func run(kubeConfig string) error {
+type rkeOptions struct {
+	path         string
+	workDir      string
+	maxProcesses int
+	logs         *runlog.Store
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM. A second
+// signal exits immediately.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logrus.Infof("Received %v, shutting down", sig)
+		cancel()
+		<-sigs
+		logrus.Fatal("Received second signal, exiting")
+	}()
+	return ctx
+}
+
+// serveHTTP serves metrics, and pprof registered by its import, until ctx is
+// done
+func serveHTTP(ctx context.Context, address string) {
+	http.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: address}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		logrus.Infof("Serving metrics on %s", address)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Fatalf("Failed to serve metrics %v", err)
+		}
+	}()
+}
+
+func run(ctx context.Context, kubeConfig string, skipCRDInstall bool, options controllers.Options, election leaderElectionConfig, rkeOpts rkeOptions) error {
 	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
 	if err != nil {
 		return err
 	}
+	if err := registerProvisioners(restConfig, options.Namespace, rkeOpts); err != nil {
+		return err
+	}
 
 	// Create custom resource definitions
-	if err := createCRDS(); err != nil {
+	if skipCRDInstall {
+		logrus.Info("Skipping CRD installation")
+	} else {
+		logrus.Info("Installing CRDs...")
+		if err := crd.Install(restConfig, crdEstablishTimeout); err != nil {
+			return err
+		}
+		logrus.Info("Installed CRDs")
+	}
+
+	if !election.enabled {
+		// Run controllers
+		workCtx, cancelWork := controllers.WorkContext(ctx, options)
+		defer cancelWork()
+		return controllers.Run(ctx, workCtx, restConfig, options)
+	}
+
+	return runLeaderElection(ctx, restConfig, options, election)
+}
+
+// runLeaderElection blocks campaigning for leadership, and runs the
+// controllers for as long as this replica holds the lease. It returns once
+// ctx is done or the lease is lost, after the controllers were stopped.
+func runLeaderElection(ctx context.Context, restConfig *rest.Config, options controllers.Options, election leaderElectionConfig) error {
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	id, err := os.Hostname()
+	if err != nil {
 		return err
 	}
 
-	// Register controllers
-	if err := controllers.Register(restConfig); err != nil {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: leaderElectionName})
+
+	lock, err := resourcelock.New(election.resourceLock, options.Namespace, leaderElectionName, kubeClient.CoreV1(),
+		resourcelock.ResourceLockConfig{
+			Identity:      id,
+			EventRecorder: recorder,
+		})
+	if err != nil {
 		return err
 	}
 
-	// Run controllers
-	logrus.Info("Running controllers")
+	// started is closed once this replica leads, stopped once its controllers
+	// returned
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	lost := make(chan struct{})
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: election.leaseDuration,
+		RenewDeadline: election.renewDeadline,
+		RetryPeriod:   election.retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				logrus.Infof("Acquired leader lease as %s", id)
+				close(started)
+				defer close(stopped)
 
-	for {
-		time.Sleep(5 * time.Second)
+				leaderCtx, cancel := context.WithCancel(ctx)
+				defer cancel()
+				// in-flight runs get the grace period on termination only,
+				// another replica may take the lease once it is lost
+				workCtx, cancelWork := controllers.WorkContext(ctx, options)
+				defer cancelWork()
+				go func() {
+					select {
+					case <-stop:
+						logrus.Warn("Lost leader lease, killing in-flight provisioning")
+						cancelWork()
+						cancel()
+					case <-leaderCtx.Done():
+					}
+				}()
+				// Run controllers
+				if err := controllers.Run(leaderCtx, workCtx, restConfig, options); err != nil {
+					logrus.Errorf("Failed to run controllers %v", err)
+				}
+			},
+			OnStoppedLeading: func() {
+				logrus.Infof("Lost leader lease as %s", id)
+				close(lost)
+			},
+			OnNewLeader: func(identity string) {
+				if identity != id {
+					logrus.Infof("Current leader is %s", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	go elector.Run()
+
+	select {
+	case <-ctx.Done():
+	case <-lost:
+	}
+	select {
+	case <-started:
+		<-stopped
+	default:
+	}
+	select {
+	case <-lost:
+		return fmt.Errorf("leader election lost")
+	default:
+		return nil
 	}
 }
 
-func createCRDS() error {
-	logrus.Info("Creating CRDs...")
-	cmdName := "kubectl"
-	files, err := ioutil.ReadDir("./config/crd")
+// registerProvisioners registers the backends, the rke state is kept in
+// secrets in namespace
+func registerProvisioners(restConfig *rest.Config, namespace string, options rkeOptions) error {
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return err
 	}
-	for _, file := range files {
-		filePath := fmt.Sprintf("./config/crd/%s", file.Name())
-		logrus.Infof("Creating crd for file %s", filePath)
-		cmdArgs := []string{"apply", "-f", filePath}
-		cmd := exec.Command(cmdName, cmdArgs...)
-		var out bytes.Buffer
-		cmd.Stderr = &out
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to create CRD [%s] %v %v", file.Name(), err, out.String())
+	state := rkestate.NewStore(kubeClient, namespace)
+	keys := func(name string) (*corev1.Secret, error) {
+		return kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	}
+	provisioner.Register(rke.Name, rke.NewProvisioner(options.path, options.workDir, options.maxProcesses, options.logs, state, keys))
+	provisioner.Register(fake.Name, fake.NewProvisioner())
+	return nil
+}
+
+// logsCommand prints the rke output of a cluster from the work dir
+func logsCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: %s logs [--attempt N] [--follow] <cluster>", c.App.Name)
+	}
+	cluster := c.Args().First()
+	store := runlog.NewStore(c.GlobalString("work-dir"), 0)
+
+	attempt := c.Int("attempt")
+	if attempt == 0 {
+		latest, err := store.Latest(cluster)
+		if err != nil {
+			return err
 		}
+		attempt = latest
+	} else if _, err := os.Stat(store.Path(cluster, attempt)); err != nil {
+		attempts, _ := store.Attempts(cluster)
+		return fmt.Errorf("no attempt %d for cluster %s, available: %v", attempt, cluster, attempts)
 	}
 
-	logrus.Info("Created CRDs")
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		close(stop)
+	}()
+	return store.Copy(os.Stdout, cluster, attempt, c.Bool("follow"), stop)
+}
+
+// getCluster returns the cluster named by the command argument, and the
+// client it was read with
+func getCluster(c *cli.Context) (clusterclient.Interface, *types.Cluster, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", c.GlobalString("kubeconfig"))
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := clusterclient.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	cluster, err := client.ClusterprovisionerV1alpha1().Clusters().Get(c.Args().First(), metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, cluster, nil
+}
+
+// secretGetter returns the secrets of the operator namespace
+func secretGetter(c *cli.Context) (sshkeys.SecretGetter, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", c.GlobalString("kubeconfig"))
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	namespace := c.GlobalString("namespace")
+	return func(name string) (*corev1.Secret, error) {
+		return kubeClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	}, nil
+}
+
+func planCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: %s plan <cluster>", c.App.Name)
+	}
+	_, cluster, err := getCluster(c)
+	if err != nil {
+		return err
+	}
+
+	config, err := rkeconfig.Render(cluster)
+	if err != nil {
+		// a legacy config path only exists on the operator host, fall back
+		// to the plan the operator stored
+		if cluster.Status.Plan == nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Showing the stored plan, failed to render config %v\n", err)
+		return plan.Print(os.Stdout, cluster.Status.Plan)
+	}
+	getSecret, err := secretGetter(c)
+	if err != nil {
+		return err
+	}
+	keys, err := sshkeys.Hash(getSecret, cluster)
+	if err != nil {
+		return err
+	}
+	changes := plan.ForCluster(cluster, config, keys)
+	if err := plan.Print(os.Stdout, changes); err != nil {
+		return err
+	}
+	if cluster.Spec.RequireApproval && changes.Disruptive && !plan.Approved(cluster, changes) {
+		fmt.Printf("Approve with: kubectl annotate cluster %s %s=%s --overwrite\n", cluster.Name, plan.ApprovedAnnotation, changes.ConfigHash)
+	}
+	return nil
+}
+
+func rollbackCommand(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("usage: %s rollback [--revision N | --list] <cluster>", c.App.Name)
+	}
+	client, cluster, err := getCluster(c)
+	if err != nil {
+		return err
+	}
+	history := cluster.Status.History
+
+	if c.Bool("list") {
+		for _, revision := range history {
+			current := ""
+			if revision.Revision == cluster.Status.Revision {
+				current = " (applied)"
+			}
+			fmt.Printf("%d\t%s\t%s%s\n", revision.Revision, revision.ConfigHash, revision.AppliedAt.Format(time.RFC3339), current)
+		}
+		return nil
+	}
+
+	revision := c.Int64("revision")
+	if revision == 0 {
+		for _, previous := range history {
+			if previous.Revision < cluster.Status.Revision {
+				revision = previous.Revision
+			}
+		}
+		if revision == 0 {
+			return fmt.Errorf("cluster %s has no revision before %d", cluster.Name, cluster.Status.Revision)
+		}
+	}
+	found := false
+	for _, entry := range history {
+		found = found || entry.Revision == revision
+	}
+	if !found {
+		return fmt.Errorf("no revision %d for cluster %s, see --list", revision, cluster.Name)
+	}
+
+	_, err = clusterutil.Update(client, cluster, func(toUpdate *types.Cluster) {
+		if toUpdate.Annotations == nil {
+			toUpdate.Annotations = map[string]string{}
+		}
+		toUpdate.Annotations[provisionercontroller.RollbackAnnotation] = strconv.FormatInt(revision, 10)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Requested rollback of cluster %s to revision %d\n", cluster.Name, revision)
 	return nil
 }
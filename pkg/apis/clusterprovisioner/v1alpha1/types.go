@@ -9,23 +9,86 @@ import (
 type ClusterConditionType string
 
 const (
-	// ClusterConditionReady Cluster ready to serve API (healthy when true, unhealthy when false)
+	// KubeconfigSecretKey is the key of the kubeconfig content in the secret a Kubeconfig references
+	KubeconfigSecretKey = "kubeconfig"
+)
+
+const (
+	// ClusterConditionReady Cluster ready to serve API, true when all the health conditions below are
 	ClusterConditionReady condition.Cond = "Ready"
+	// ClusterConditionAPIReachable Cluster API server answers its health endpoints in time
+	ClusterConditionAPIReachable condition.Cond = "APIReachable"
+	// ClusterConditionNodesReady Cluster nodes expected from the spec are registered and Ready
+	ClusterConditionNodesReady condition.Cond = "NodesReady"
+	// ClusterConditionControlPlaneHealthy Cluster scheduler, controller manager and kube-system pods are healthy
+	ClusterConditionControlPlaneHealthy condition.Cond = "ControlPlaneHealthy"
+	// ClusterConditionEtcdHealthy Cluster etcd members are healthy
+	ClusterConditionEtcdHealthy condition.Cond = "EtcdHealthy"
 	// ClusterConditionProvisioned Cluster is provisioned by RKE
 	ClusterConditionProvisioned condition.Cond = "Provisioned"
+	// ClusterConditionStalled Cluster failed too many times in a row and isn't retried until its spec changes
+	ClusterConditionStalled condition.Cond = "Stalled"
+	// ClusterConditionAwaitingApproval Cluster has disruptive changes pending that aren't approved yet
+	ClusterConditionAwaitingApproval condition.Cond = "AwaitingApproval"
+	// ClusterConditionRolledBack Cluster runs a config from its history rather than the desired one
+	ClusterConditionRolledBack condition.Cond = "RolledBack"
+)
+
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete tears down the cluster when its resource is deleted
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan keeps the cluster running when its resource is deleted
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+type RemediationAction string
+
+const (
+	// RemediationActionNone only reports the sustained failures
+	RemediationActionNone RemediationAction = "None"
+	// RemediationActionReprovision runs the provisioner again with the
+	// applied config
+	RemediationActionReprovision RemediationAction = "Reprovision"
+	// RemediationActionRestartControlPlane restarts the control plane
+	// components through the provisioner backend
+	RemediationActionRestartControlPlane RemediationAction = "RestartControlPlane"
+)
+
+type RemediationResult string
+
+const (
+	RemediationResultSucceeded RemediationResult = "Succeeded"
+	RemediationResultFailed    RemediationResult = "Failed"
+)
+
+type PlanChangeType string
+
+const (
+	PlanChangeNodeAdded                PlanChangeType = "NodeAdded"
+	PlanChangeNodeRemoved              PlanChangeType = "NodeRemoved"
+	PlanChangeRolesChanged             PlanChangeType = "RolesChanged"
+	PlanChangeKubernetesVersionChanged PlanChangeType = "KubernetesVersionChanged"
+	PlanChangeServiceChanged           PlanChangeType = "ServiceChanged"
+	PlanChangeNetworkChanged           PlanChangeType = "NetworkChanged"
+	PlanChangeSSHKeysChanged           PlanChangeType = "SSHKeysChanged"
+	// PlanChangeConfigChanged is reported when either config can't be
+	// compared structurally
+	PlanChangeConfigChanged PlanChangeType = "ConfigChanged"
 )
 
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +resource:path=cluster
-// +genclient:noStatus
 // +genclient:nonNamespaced
 
 type Cluster struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec   ClusterSpec   `json:"spec"`
+	Spec ClusterSpec `json:"spec"`
+	// +optional
 	Status ClusterStatus `json:"status"`
 }
 
@@ -60,11 +123,186 @@ type KubeconfigList struct {
 	Items           []Kubeconfig `json:"items"`
 }
 type KubeconfigSpec struct {
-	ConfigPath string `json: "configPath, omitempty"`
+	// SecretRef is the secret holding the kubeconfig content under KubeconfigSecretKey
+	SecretRef v1.SecretReference `json:"secretRef"`
 }
 
+// ClusterSpec is the desired state of a cluster, either RKEConfig, the
+// legacy ConfigPath or Import must be set
+// +validation:RequireAnyOf=configPath;rkeConfig;import
 type ClusterSpec struct {
-	ConfigPath string `json: "configPath, omitempty"`
+	// ConfigPath is the legacy path to an rke cluster.yml on the operator host,
+	// used only when RKEConfig is not set
+	// +validation:Pattern=^/
+	ConfigPath string `json:"configPath,omitempty"`
+	// Provisioner is the name of the backend provisioning the cluster, rke when empty
+	Provisioner string `json:"provisioner,omitempty"`
+	// RKEConfig is the cluster definition rendered into rke cluster.yml
+	RKEConfig *RKEConfig `json:"rkeConfig,omitempty"`
+	// Timeout bounds each provisioner run for the cluster, the operator
+	// default applies when it isn't set
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// RequireApproval holds off disruptive changes until the plan is approved
+	// through the clusterprovisioner.rke.io/approved-plan annotation
+	RequireApproval bool `json:"requireApproval,omitempty"`
+	// Rollback restores the applied config when changes keep failing
+	Rollback *RollbackPolicy `json:"rollback,omitempty"`
+	// Import adopts an existing cluster, it is never provisioned nor torn
+	// down and the other settings are ignored
+	Import *ImportSpec `json:"import,omitempty"`
+	// DeletionPolicy tells whether deleting the resource tears down the
+	// cluster, Delete when empty. Imported clusters are always orphaned.
+	// +validation:Enum=Delete;Orphan
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// HealthCheck tunes the health probes of the cluster, the operator
+	// defaults apply to the settings left empty
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
+	// Remediation acts on the cluster once it kept failing its health checks,
+	// it doesn't apply to imported clusters
+	Remediation *RemediationPolicy `json:"remediation,omitempty"`
+}
+
+// RemediationPolicy configures the remediation of unhealthy clusters
+type RemediationPolicy struct {
+	// FailureThreshold is the number of consecutive failed health checks
+	// that triggers a remediation
+	// +validation:Minimum=1
+	FailureThreshold int `json:"failureThreshold"`
+	// Cooldown is the minimum time between two remediations of the cluster,
+	// 30 minutes when it isn't set
+	Cooldown *metav1.Duration `json:"cooldown,omitempty"`
+	// Action run on the cluster, None when empty
+	// +validation:Enum=None;Reprovision;RestartControlPlane
+	Action RemediationAction `json:"action,omitempty"`
+}
+
+// HealthCheckSpec configures the periodic health probes of a cluster
+type HealthCheckSpec struct {
+	// Interval between two probe runs, jitter is added on top
+	Interval *metav1.Duration `json:"interval,omitempty"`
+	// Jitter is the maximum random delay added to the interval, spreading the
+	// probes of clusters provisioned together. The operator default is a
+	// fraction of the interval.
+	Jitter *metav1.Duration `json:"jitter,omitempty"`
+	// Timeout bounds each request the probes make to the cluster
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ImportSpec references the access to a cluster created outside the operator
+type ImportSpec struct {
+	// KubeconfigSecret is the name of the secret in the operator namespace
+	// holding the kubeconfig of the cluster under the kubeconfig key
+	// +validation:MinLength=1
+	KubeconfigSecret string `json:"kubeconfigSecret"`
+}
+
+// RollbackPolicy configures the automatic rollback of failed changes, only
+// supported with an inline RKEConfig
+type RollbackPolicy struct {
+	// AfterFailures is the number of consecutive failed runs of a change after
+	// which the applied config is restored
+	AfterFailures int `json:"afterFailures"`
+}
+
+// RKEConfig mirrors the subset of rke cluster.yml managed by the operator.
+// The yaml tags follow the rke file format, the SSH key secrets are operator
+// settings left out of it.
+type RKEConfig struct {
+	// Nodes of the cluster
+	// +validation:MinItems=1
+	Nodes []RKEConfigNode `json:"nodes" yaml:"nodes"`
+	// Services are the kubernetes components configuration
+	Services RKEConfigServices `json:"services,omitempty" yaml:"services,omitempty"`
+	// Network is the network plugin configuration
+	Network NetworkConfig `json:"network,omitempty" yaml:"network,omitempty"`
+	// KubernetesVersion to deploy, rke default when empty
+	KubernetesVersion string `json:"kubernetesVersion,omitempty" yaml:"kubernetes_version,omitempty"`
+	// SSHKeySecret is the SSHKeySecret of the nodes that set neither it nor
+	// SSHKeyPath
+	SSHKeySecret string `json:"sshKeySecret,omitempty" yaml:"-"`
+}
+
+type RKEConfigNode struct {
+	// Address is the IP or hostname rke connects to over SSH
+	// +validation:MinLength=1
+	Address string `json:"address" yaml:"address"`
+	// User is the SSH user
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// Role is the list of roles of the node: etcd, controlplane and/or worker
+	// +validation:MinItems=1
+	// +validation:Enum=etcd;controlplane;worker
+	Role []string `json:"role" yaml:"role"`
+	// HostnameOverride is the name the node is registered with in kubernetes
+	HostnameOverride string `json:"hostnameOverride,omitempty" yaml:"hostname_override,omitempty"`
+	// SSHKeyPath is the path of the SSH private key on the operator host
+	SSHKeyPath string `json:"sshKeyPath,omitempty" yaml:"ssh_key_path,omitempty"`
+	// SSHKeySecret is the name of the secret in the operator namespace
+	// holding the SSH private key under ssh-privatekey, it takes precedence
+	// over SSHKeyPath
+	SSHKeySecret string `json:"sshKeySecret,omitempty" yaml:"-"`
+}
+
+type RKEConfigServices struct {
+	Etcd           ETCDService           `json:"etcd,omitempty" yaml:"etcd,omitempty"`
+	KubeAPI        KubeAPIService        `json:"kubeApi,omitempty" yaml:"kube-api,omitempty"`
+	KubeController KubeControllerService `json:"kubeController,omitempty" yaml:"kube-controller,omitempty"`
+	Scheduler      SchedulerService      `json:"scheduler,omitempty" yaml:"scheduler,omitempty"`
+	Kubelet        KubeletService        `json:"kubelet,omitempty" yaml:"kubelet,omitempty"`
+	Kubeproxy      KubeproxyService      `json:"kubeproxy,omitempty" yaml:"kubeproxy,omitempty"`
+}
+
+type BaseService struct {
+	// Image overrides the docker image of the service
+	Image string `json:"image,omitempty" yaml:"image,omitempty"`
+	// ExtraArgs are passed to the service binary
+	ExtraArgs map[string]string `json:"extraArgs,omitempty" yaml:"extra_args,omitempty"`
+}
+
+type ETCDService struct {
+	BaseService `json:",inline" yaml:",inline"`
+}
+
+type KubeAPIService struct {
+	BaseService `json:",inline" yaml:",inline"`
+	// ServiceClusterIPRange is the virtual IP range of services
+	// +validation:Format=cidr
+	ServiceClusterIPRange string `json:"serviceClusterIpRange,omitempty" yaml:"service_cluster_ip_range,omitempty"`
+}
+
+type KubeControllerService struct {
+	BaseService `json:",inline" yaml:",inline"`
+	// ClusterCIDR is the pod IP range
+	// +validation:Format=cidr
+	ClusterCIDR string `json:"clusterCidr,omitempty" yaml:"cluster_cidr,omitempty"`
+	// ServiceClusterIPRange is the virtual IP range of services
+	// +validation:Format=cidr
+	ServiceClusterIPRange string `json:"serviceClusterIpRange,omitempty" yaml:"service_cluster_ip_range,omitempty"`
+}
+
+type SchedulerService struct {
+	BaseService `json:",inline" yaml:",inline"`
+}
+
+type KubeletService struct {
+	BaseService `json:",inline" yaml:",inline"`
+	// ClusterDomain is the cluster DNS domain
+	// +validation:Format=hostname
+	ClusterDomain string `json:"clusterDomain,omitempty" yaml:"cluster_domain,omitempty"`
+	// ClusterDNSServer is the IP of the cluster DNS service
+	// +validation:Format=ipv4
+	ClusterDNSServer string `json:"clusterDnsServer,omitempty" yaml:"cluster_dns_server,omitempty"`
+}
+
+type KubeproxyService struct {
+	BaseService `json:",inline" yaml:",inline"`
+}
+
+type NetworkConfig struct {
+	// Plugin is the network plugin: flannel, calico or canal
+	// +validation:Enum=flannel;calico;canal
+	Plugin string `json:"plugin,omitempty" yaml:"plugin,omitempty"`
+	// Options are plugin specific settings
+	Options map[string]string `json:"options,omitempty" yaml:"options,omitempty"`
 }
 
 type ClusterStatus struct {
@@ -72,6 +310,69 @@ type ClusterStatus struct {
 	//Conditions represent the latest available observations of an object's current state:
 	//More info: https://github.com/kubernetes/community/blob/master/contributors/devel/api-conventions.md#typical-status-properties
 	Conditions []ClusterCondition `json:"conditions,omitempty"`
+	// Plan describes the changes the next provisioner run makes, it is cleared
+	// once they are applied
+	Plan *ClusterPlan `json:"plan,omitempty"`
+	// FailedAttempts counts the consecutive failed runs of the planned config
+	FailedAttempts int `json:"failedAttempts,omitempty"`
+	// Revision of the applied config
+	Revision int64 `json:"revision,omitempty"`
+	// History holds the last applied configs, the newest last
+	History []ConfigRevision `json:"history,omitempty"`
+	// HeldConfigHash is the desired config that was rolled back from, it
+	// isn't applied again until it changes
+	HeldConfigHash string `json:"heldConfigHash,omitempty"`
+	// AppliedSSHKeys identifies the content of the SSH keys the applied
+	// config was provisioned with
+	AppliedSSHKeys string `json:"appliedSshKeys,omitempty"`
+	// Remediations holds the last remediation attempts, the newest last
+	Remediations []RemediationAttempt `json:"remediations,omitempty"`
+}
+
+// RemediationAttempt is a remediation run on an unhealthy cluster
+type RemediationAttempt struct {
+	Action RemediationAction `json:"action"`
+	// Trigger is the health check failure the remediation was triggered by
+	Trigger   string      `json:"trigger,omitempty"`
+	StartedAt metav1.Time `json:"startedAt"`
+	// FinishedAt and Result are empty while the remediation runs
+	FinishedAt *metav1.Time      `json:"finishedAt,omitempty"`
+	Result     RemediationResult `json:"result,omitempty"`
+	Message    string            `json:"message,omitempty"`
+}
+
+// ConfigRevision is a config that was successfully applied
+type ConfigRevision struct {
+	Revision   int64  `json:"revision"`
+	ConfigHash string `json:"configHash"`
+	// Config is the rendered rke cluster.yml
+	Config    string      `json:"config"`
+	AppliedAt metav1.Time `json:"appliedAt,omitempty"`
+}
+
+// ClusterPlan is the difference between the desired and the applied config
+type ClusterPlan struct {
+	// ConfigHash identifies the desired config the plan was computed for
+	ConfigHash string `json:"configHash"`
+	// Changes ordered by type and target
+	Changes []PlanChange `json:"changes,omitempty"`
+	// Disruptive is set when any of the changes is
+	Disruptive bool `json:"disruptive,omitempty"`
+}
+
+type PlanChange struct {
+	// Type of the change
+	// +validation:Enum=NodeAdded;NodeRemoved;RolesChanged;KubernetesVersionChanged;ServiceChanged;NetworkChanged;SSHKeysChanged;ConfigChanged
+	Type PlanChangeType `json:"type"`
+	// Target is the node address or the service the change applies to
+	Target string `json:"target,omitempty"`
+	// From is the applied value
+	From string `json:"from,omitempty"`
+	// To is the desired value
+	To string `json:"to,omitempty"`
+	// Disruptive changes may cause downtime or data loss: nodes removed,
+	// roles changed, kubernetes version or network plugin changed
+	Disruptive bool `json:"disruptive,omitempty"`
 }
 
 type ClusterCondition struct {
@@ -21,6 +21,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	conversion "k8s.io/apimachinery/pkg/conversion"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	reflect "reflect"
@@ -36,6 +37,10 @@ func init() {
 // Deprecated: deepcopy registration will go away when static deepcopy is fully implemented.
 func RegisterDeepCopies(scheme *runtime.Scheme) error {
 	return scheme.AddGeneratedDeepCopyFuncs(
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*BaseService).DeepCopyInto(out.(*BaseService))
+			return nil
+		}, InType: reflect.TypeOf(&BaseService{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*Cluster).DeepCopyInto(out.(*Cluster))
 			return nil
@@ -48,6 +53,10 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*ClusterList).DeepCopyInto(out.(*ClusterList))
 			return nil
 		}, InType: reflect.TypeOf(&ClusterList{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ClusterPlan).DeepCopyInto(out.(*ClusterPlan))
+			return nil
+		}, InType: reflect.TypeOf(&ClusterPlan{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*ClusterSpec).DeepCopyInto(out.(*ClusterSpec))
 			return nil
@@ -56,6 +65,30 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*ClusterStatus).DeepCopyInto(out.(*ClusterStatus))
 			return nil
 		}, InType: reflect.TypeOf(&ClusterStatus{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ConfigRevision).DeepCopyInto(out.(*ConfigRevision))
+			return nil
+		}, InType: reflect.TypeOf(&ConfigRevision{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ETCDService).DeepCopyInto(out.(*ETCDService))
+			return nil
+		}, InType: reflect.TypeOf(&ETCDService{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*HealthCheckSpec).DeepCopyInto(out.(*HealthCheckSpec))
+			return nil
+		}, InType: reflect.TypeOf(&HealthCheckSpec{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*ImportSpec).DeepCopyInto(out.(*ImportSpec))
+			return nil
+		}, InType: reflect.TypeOf(&ImportSpec{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*KubeAPIService).DeepCopyInto(out.(*KubeAPIService))
+			return nil
+		}, InType: reflect.TypeOf(&KubeAPIService{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*KubeControllerService).DeepCopyInto(out.(*KubeControllerService))
+			return nil
+		}, InType: reflect.TypeOf(&KubeControllerService{})},
 		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
 			in.(*Kubeconfig).DeepCopyInto(out.(*Kubeconfig))
 			return nil
@@ -68,15 +101,82 @@ func RegisterDeepCopies(scheme *runtime.Scheme) error {
 			in.(*KubeconfigSpec).DeepCopyInto(out.(*KubeconfigSpec))
 			return nil
 		}, InType: reflect.TypeOf(&KubeconfigSpec{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*KubeletService).DeepCopyInto(out.(*KubeletService))
+			return nil
+		}, InType: reflect.TypeOf(&KubeletService{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*KubeproxyService).DeepCopyInto(out.(*KubeproxyService))
+			return nil
+		}, InType: reflect.TypeOf(&KubeproxyService{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*NetworkConfig).DeepCopyInto(out.(*NetworkConfig))
+			return nil
+		}, InType: reflect.TypeOf(&NetworkConfig{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*PlanChange).DeepCopyInto(out.(*PlanChange))
+			return nil
+		}, InType: reflect.TypeOf(&PlanChange{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RKEConfig).DeepCopyInto(out.(*RKEConfig))
+			return nil
+		}, InType: reflect.TypeOf(&RKEConfig{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RKEConfigNode).DeepCopyInto(out.(*RKEConfigNode))
+			return nil
+		}, InType: reflect.TypeOf(&RKEConfigNode{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RKEConfigServices).DeepCopyInto(out.(*RKEConfigServices))
+			return nil
+		}, InType: reflect.TypeOf(&RKEConfigServices{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RemediationAttempt).DeepCopyInto(out.(*RemediationAttempt))
+			return nil
+		}, InType: reflect.TypeOf(&RemediationAttempt{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RemediationPolicy).DeepCopyInto(out.(*RemediationPolicy))
+			return nil
+		}, InType: reflect.TypeOf(&RemediationPolicy{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*RollbackPolicy).DeepCopyInto(out.(*RollbackPolicy))
+			return nil
+		}, InType: reflect.TypeOf(&RollbackPolicy{})},
+		conversion.GeneratedDeepCopyFunc{Fn: func(in interface{}, out interface{}, c *conversion.Cloner) error {
+			in.(*SchedulerService).DeepCopyInto(out.(*SchedulerService))
+			return nil
+		}, InType: reflect.TypeOf(&SchedulerService{})},
 	)
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BaseService) DeepCopyInto(out *BaseService) {
+	*out = *in
+	if in.ExtraArgs != nil {
+		in, out := &in.ExtraArgs, &out.ExtraArgs
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BaseService.
+func (in *BaseService) DeepCopy() *BaseService {
+	if in == nil {
+		return nil
+	}
+	out := new(BaseService)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Cluster) DeepCopyInto(out *Cluster) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 	return
 }
@@ -150,9 +250,84 @@ func (in *ClusterList) DeepCopyObject() runtime.Object {
 	}
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPlan) DeepCopyInto(out *ClusterPlan) {
+	*out = *in
+	if in.Changes != nil {
+		in, out := &in.Changes, &out.Changes
+		*out = make([]PlanChange, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPlan.
+func (in *ClusterPlan) DeepCopy() *ClusterPlan {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPlan)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterSpec) DeepCopyInto(out *ClusterSpec) {
 	*out = *in
+	if in.RKEConfig != nil {
+		in, out := &in.RKEConfig, &out.RKEConfig
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(RKEConfig)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Duration)
+			**out = **in
+		}
+	}
+	if in.Rollback != nil {
+		in, out := &in.Rollback, &out.Rollback
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(RollbackPolicy)
+			**out = **in
+		}
+	}
+	if in.Import != nil {
+		in, out := &in.Import, &out.Import
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ImportSpec)
+			**out = **in
+		}
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(HealthCheckSpec)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.Remediation != nil {
+		in, out := &in.Remediation, &out.Remediation
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(RemediationPolicy)
+			(*in).DeepCopyInto(*out)
+		}
+	}
 	return
 }
 
@@ -174,6 +349,29 @@ func (in *ClusterStatus) DeepCopyInto(out *ClusterStatus) {
 		*out = make([]ClusterCondition, len(*in))
 		copy(*out, *in)
 	}
+	if in.Plan != nil {
+		in, out := &in.Plan, &out.Plan
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(ClusterPlan)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]ConfigRevision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Remediations != nil {
+		in, out := &in.Remediations, &out.Remediations
+		*out = make([]RemediationAttempt, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -187,6 +385,133 @@ func (in *ClusterStatus) DeepCopy() *ClusterStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigRevision) DeepCopyInto(out *ConfigRevision) {
+	*out = *in
+	in.AppliedAt.DeepCopyInto(&out.AppliedAt)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigRevision.
+func (in *ConfigRevision) DeepCopy() *ConfigRevision {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigRevision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ETCDService) DeepCopyInto(out *ETCDService) {
+	*out = *in
+	in.BaseService.DeepCopyInto(&out.BaseService)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ETCDService.
+func (in *ETCDService) DeepCopy() *ETCDService {
+	if in == nil {
+		return nil
+	}
+	out := new(ETCDService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HealthCheckSpec) DeepCopyInto(out *HealthCheckSpec) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Duration)
+			**out = **in
+		}
+	}
+	if in.Jitter != nil {
+		in, out := &in.Jitter, &out.Jitter
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Duration)
+			**out = **in
+		}
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Duration)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HealthCheckSpec.
+func (in *HealthCheckSpec) DeepCopy() *HealthCheckSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HealthCheckSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImportSpec) DeepCopyInto(out *ImportSpec) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImportSpec.
+func (in *ImportSpec) DeepCopy() *ImportSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImportSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeAPIService) DeepCopyInto(out *KubeAPIService) {
+	*out = *in
+	in.BaseService.DeepCopyInto(&out.BaseService)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeAPIService.
+func (in *KubeAPIService) DeepCopy() *KubeAPIService {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeAPIService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeControllerService) DeepCopyInto(out *KubeControllerService) {
+	*out = *in
+	in.BaseService.DeepCopyInto(&out.BaseService)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeControllerService.
+func (in *KubeControllerService) DeepCopy() *KubeControllerService {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeControllerService)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Kubeconfig) DeepCopyInto(out *Kubeconfig) {
 	*out = *in
@@ -252,6 +577,7 @@ func (in *KubeconfigList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KubeconfigSpec) DeepCopyInto(out *KubeconfigSpec) {
 	*out = *in
+	out.SecretRef = in.SecretRef
 	return
 }
 
@@ -264,3 +590,228 @@ func (in *KubeconfigSpec) DeepCopy() *KubeconfigSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeletService) DeepCopyInto(out *KubeletService) {
+	*out = *in
+	in.BaseService.DeepCopyInto(&out.BaseService)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeletService.
+func (in *KubeletService) DeepCopy() *KubeletService {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeletService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubeproxyService) DeepCopyInto(out *KubeproxyService) {
+	*out = *in
+	in.BaseService.DeepCopyInto(&out.BaseService)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubeproxyService.
+func (in *KubeproxyService) DeepCopy() *KubeproxyService {
+	if in == nil {
+		return nil
+	}
+	out := new(KubeproxyService)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NetworkConfig) DeepCopyInto(out *NetworkConfig) {
+	*out = *in
+	if in.Options != nil {
+		in, out := &in.Options, &out.Options
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkConfig.
+func (in *NetworkConfig) DeepCopy() *NetworkConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(NetworkConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlanChange) DeepCopyInto(out *PlanChange) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlanChange.
+func (in *PlanChange) DeepCopy() *PlanChange {
+	if in == nil {
+		return nil
+	}
+	out := new(PlanChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKEConfig) DeepCopyInto(out *RKEConfig) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]RKEConfigNode, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Services.DeepCopyInto(&out.Services)
+	in.Network.DeepCopyInto(&out.Network)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKEConfig.
+func (in *RKEConfig) DeepCopy() *RKEConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RKEConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKEConfigNode) DeepCopyInto(out *RKEConfigNode) {
+	*out = *in
+	if in.Role != nil {
+		in, out := &in.Role, &out.Role
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKEConfigNode.
+func (in *RKEConfigNode) DeepCopy() *RKEConfigNode {
+	if in == nil {
+		return nil
+	}
+	out := new(RKEConfigNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKEConfigServices) DeepCopyInto(out *RKEConfigServices) {
+	*out = *in
+	in.Etcd.DeepCopyInto(&out.Etcd)
+	in.KubeAPI.DeepCopyInto(&out.KubeAPI)
+	in.KubeController.DeepCopyInto(&out.KubeController)
+	in.Scheduler.DeepCopyInto(&out.Scheduler)
+	in.Kubelet.DeepCopyInto(&out.Kubelet)
+	in.Kubeproxy.DeepCopyInto(&out.Kubeproxy)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKEConfigServices.
+func (in *RKEConfigServices) DeepCopy() *RKEConfigServices {
+	if in == nil {
+		return nil
+	}
+	out := new(RKEConfigServices)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemediationAttempt) DeepCopyInto(out *RemediationAttempt) {
+	*out = *in
+	in.StartedAt.DeepCopyInto(&out.StartedAt)
+	if in.FinishedAt != nil {
+		in, out := &in.FinishedAt, &out.FinishedAt
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Time)
+			(*in).DeepCopyInto(*out)
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemediationAttempt.
+func (in *RemediationAttempt) DeepCopy() *RemediationAttempt {
+	if in == nil {
+		return nil
+	}
+	out := new(RemediationAttempt)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemediationPolicy) DeepCopyInto(out *RemediationPolicy) {
+	*out = *in
+	if in.Cooldown != nil {
+		in, out := &in.Cooldown, &out.Cooldown
+		if *in == nil {
+			*out = nil
+		} else {
+			*out = new(v1.Duration)
+			**out = **in
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemediationPolicy.
+func (in *RemediationPolicy) DeepCopy() *RemediationPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RemediationPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RollbackPolicy) DeepCopyInto(out *RollbackPolicy) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RollbackPolicy.
+func (in *RollbackPolicy) DeepCopy() *RollbackPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RollbackPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SchedulerService) DeepCopyInto(out *SchedulerService) {
+	*out = *in
+	in.BaseService.DeepCopyInto(&out.BaseService)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulerService.
+func (in *SchedulerService) DeepCopy() *SchedulerService {
+	if in == nil {
+		return nil
+	}
+	out := new(SchedulerService)
+	in.DeepCopyInto(out)
+	return out
+}
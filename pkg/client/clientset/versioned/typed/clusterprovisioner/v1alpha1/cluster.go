@@ -35,6 +35,7 @@ type ClustersGetter interface {
 type ClusterInterface interface {
 	Create(*v1alpha1.Cluster) (*v1alpha1.Cluster, error)
 	Update(*v1alpha1.Cluster) (*v1alpha1.Cluster, error)
+	UpdateStatus(*v1alpha1.Cluster) (*v1alpha1.Cluster, error)
 	Delete(name string, options *v1.DeleteOptions) error
 	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
 	Get(name string, options v1.GetOptions) (*v1alpha1.Cluster, error)
@@ -111,6 +112,21 @@ func (c *clusters) Update(cluster *v1alpha1.Cluster) (result *v1alpha1.Cluster,
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *clusters) UpdateStatus(cluster *v1alpha1.Cluster) (result *v1alpha1.Cluster, err error) {
+	result = &v1alpha1.Cluster{}
+	err = c.client.Put().
+		Resource("clusters").
+		Name(cluster.Name).
+		SubResource("status").
+		Body(cluster).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the cluster and deletes it. Returns an error if one occurs.
 func (c *clusters) Delete(name string, options *v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -0,0 +1,49 @@
+// Package clustertest provides fakes for the tests of the cluster controllers
+package clustertest
+
+import (
+	"fmt"
+	"strconv"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	clusterfake "github.com/rancher/kubecon2018/pkg/client/clientset/versioned/fake"
+	"github.com/rancher/kubecon2018/pkg/client/clientset/versioned/scheme"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// NewClientset returns a fake clientset holding objects. Unlike the generated
+// one, it bumps the resource version of the clusters it updates and refuses
+// updates based on a stale one with a conflict, like the API server does.
+func NewClientset(objects ...runtime.Object) (*clusterfake.Clientset, error) {
+	tracker := k8stesting.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	for _, obj := range objects {
+		if err := tracker.Add(obj); err != nil {
+			return nil, err
+		}
+	}
+	client := clusterfake.NewSimpleClientset()
+	client.PrependReactor("*", "*", k8stesting.ObjectReaction(tracker))
+	client.PrependReactor("update", "clusters", checkResourceVersion(tracker))
+	return client, nil
+}
+
+func checkResourceVersion(tracker k8stesting.ObjectTracker) k8stesting.ReactionFunc {
+	return func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cluster := action.(k8stesting.UpdateAction).GetObject().(*types.Cluster)
+		stored, err := tracker.Get(action.GetResource(), "", cluster.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		version := stored.(*types.Cluster).ResourceVersion
+		if cluster.ResourceVersion != version {
+			return true, nil, apierrors.NewConflict(action.GetResource().GroupResource(), cluster.Name,
+				fmt.Errorf("resource version %s, stored %s", cluster.ResourceVersion, version))
+		}
+		n, _ := strconv.Atoi(version)
+		updated := cluster.DeepCopy()
+		updated.ResourceVersion = strconv.Itoa(n + 1)
+		return true, updated, tracker.Update(action.GetResource(), updated, "")
+	}
+}
@@ -0,0 +1,98 @@
+package clusterutil
+
+import (
+	"reflect"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	clusterclient "github.com/rancher/kubecon2018/pkg/client/clientset/versioned"
+	"github.com/rancher/norman/condition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// UpdateStatus applies mutate to a copy of the cluster and writes the result
+// through the status subresource. The cluster is usually the cached copy from
+// a lister; on conflict the latest version is fetched and mutate applied
+// again. Nothing is written when mutate leaves the status unchanged.
+func UpdateStatus(client clusterclient.Interface, cluster *types.Cluster, mutate func(cluster *types.Cluster)) (*types.Cluster, error) {
+	return update(client, cluster, mutate, func(latest, toUpdate *types.Cluster) (*types.Cluster, error) {
+		if reflect.DeepEqual(latest.Status, toUpdate.Status) {
+			return latest, nil
+		}
+		return client.ClusterprovisionerV1alpha1().Clusters().UpdateStatus(toUpdate)
+	})
+}
+
+// Update is UpdateStatus for the cluster metadata and spec
+func Update(client clusterclient.Interface, cluster *types.Cluster, mutate func(cluster *types.Cluster)) (*types.Cluster, error) {
+	return update(client, cluster, mutate, func(latest, toUpdate *types.Cluster) (*types.Cluster, error) {
+		if reflect.DeepEqual(latest.ObjectMeta, toUpdate.ObjectMeta) && reflect.DeepEqual(latest.Spec, toUpdate.Spec) {
+			return latest, nil
+		}
+		return client.ClusterprovisionerV1alpha1().Clusters().Update(toUpdate)
+	})
+}
+
+func update(client clusterclient.Interface, cluster *types.Cluster, mutate func(cluster *types.Cluster),
+	write func(latest, toUpdate *types.Cluster) (*types.Cluster, error)) (*types.Cluster, error) {
+	var result *types.Cluster
+	latest := cluster
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		if latest == nil {
+			latest, err = client.ClusterprovisionerV1alpha1().Clusters().Get(cluster.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+		}
+		toUpdate := latest.DeepCopy()
+		mutate(toUpdate)
+		result, err = write(latest, toUpdate)
+		if err != nil {
+			latest = nil
+		}
+		return err
+	})
+	return result, err
+}
+
+// OwnerReference returns the reference making the cluster the controller of
+// the objects created for it, they are garbage collected with the cluster
+func OwnerReference(cluster *types.Cluster) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{
+		Name:       cluster.Name,
+		APIVersion: types.SchemeGroupVersion.String(),
+		UID:        cluster.UID,
+		Kind:       "Cluster",
+		Controller: &controller,
+	}
+}
+
+// IsOwner tells whether the cluster is among the owners of an object
+func IsOwner(cluster *types.Cluster, owners []metav1.OwnerReference) bool {
+	for _, owner := range owners {
+		if owner.UID == cluster.UID {
+			return true
+		}
+	}
+	return false
+}
+
+// CopyCondition sets cond on dst to its value on src, leaving the other
+// conditions of dst untouched
+func CopyCondition(dst, src *types.Cluster, cond condition.Cond) {
+	for _, c := range src.Status.Conditions {
+		if c.Type != types.ClusterConditionType(cond) {
+			continue
+		}
+		for i := range dst.Status.Conditions {
+			if dst.Status.Conditions[i].Type == c.Type {
+				dst.Status.Conditions[i] = c
+				return
+			}
+		}
+		dst.Status.Conditions = append(dst.Status.Conditions, c)
+		return
+	}
+}
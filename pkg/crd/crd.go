@@ -0,0 +1,129 @@
+package crd
+
+//go:generate go run generate.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/sirupsen/logrus"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	resource     = "customresourcedefinitions"
+	pollInterval = 500 * time.Millisecond
+)
+
+// Install creates or updates the CRDs embedded in the binary, and waits up to
+// timeout for each of them to be Established.
+//
+// The manifests are sent as is rather than through the typed client, so
+// fields the vendored apiextensions types don't know about are kept.
+func Install(config *rest.Config, timeout time.Duration) error {
+	client, err := apiextensionsclient.NewForConfig(config)
+	if err != nil {
+		return err
+	}
+
+	var files []string
+	for file := range definitions {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	var names []string
+	for _, file := range files {
+		name, err := apply(client, definitions[file])
+		if err != nil {
+			return fmt.Errorf("failed to apply CRD [%s] %v", file, err)
+		}
+		logrus.Infof("Applied CRD %s", name)
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if err := waitEstablished(client, name, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply creates the CRD described by manifest, or replaces the spec of the
+// existing one, and returns its name
+func apply(client apiextensionsclient.Interface, manifest string) (string, error) {
+	content, err := yaml.YAMLToJSON([]byte(manifest))
+	if err != nil {
+		return "", err
+	}
+	definition := map[string]interface{}{}
+	if err := json.Unmarshal(content, &definition); err != nil {
+		return "", err
+	}
+	metadata, ok := definition["metadata"].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("manifest has no metadata")
+	}
+	name, _ := metadata["name"].(string)
+	if name == "" {
+		return "", fmt.Errorf("manifest has no name")
+	}
+
+	crds := client.ApiextensionsV1beta1().CustomResourceDefinitions()
+	restClient := client.ApiextensionsV1beta1().RESTClient()
+	return name, retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		existing, err := crds.Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			err = restClient.Post().Resource(resource).Body(content).Do().Error()
+			if apierrors.IsAlreadyExists(err) {
+				// created concurrently, replace it on the next attempt
+				return apierrors.NewConflict(apiextensionsv1beta1.Resource(resource), name, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+		metadata["resourceVersion"] = existing.ResourceVersion
+		body, err := json.Marshal(definition)
+		if err != nil {
+			return err
+		}
+		return restClient.Put().Resource(resource).Name(name).Body(body).Do().Error()
+	})
+}
+
+// waitEstablished polls the CRD until the apiserver serves it
+func waitEstablished(client apiextensionsclient.Interface, name string, timeout time.Duration) error {
+	err := wait.PollImmediate(pollInterval, timeout, func() (bool, error) {
+		crd, err := client.ApiextensionsV1beta1().CustomResourceDefinitions().Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, cond := range crd.Status.Conditions {
+			switch cond.Type {
+			case apiextensionsv1beta1.NamesAccepted:
+				if cond.Status == apiextensionsv1beta1.ConditionFalse {
+					return false, fmt.Errorf("names of CRD %s were not accepted: %s", name, cond.Message)
+				}
+			case apiextensionsv1beta1.Established:
+				if cond.Status == apiextensionsv1beta1.ConditionTrue {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("CRD %s not established after %v", name, timeout)
+	}
+	return err
+}
@@ -0,0 +1,484 @@
+// +build ignore
+
+// generate renders the CRD manifests of the operator to config/crd and embeds
+// them into the crd package, so the operator doesn't depend on its working
+// directory to install them.
+//
+// The OpenAPI v3 validation schemas are built from the Go types in
+// pkg/apis/clusterprovisioner/v1alpha1. A field is required unless its json tag
+// has omitempty or its comment has a +optional marker. These markers refine the
+// schema of a field:
+//
+//	+validation:Enum=a;b        allowed values, of the items for a slice
+//	+validation:Format=cidr     OpenAPI format
+//	+validation:Pattern=^/      regular expression
+//	+validation:MinLength=1
+//	+validation:MinItems=1
+//	+validation:Minimum=1
+//
+// and +validation:RequireAnyOf=a;b on a struct type requires at least one of
+// the listed fields to be set.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+)
+
+const (
+	group       = "clusterprovisioner.rke.io"
+	version     = "v1alpha1"
+	typesDir    = "../apis/clusterprovisioner/v1alpha1"
+	manifestDir = "../../config/crd"
+	outputFile  = "zz_generated.definitions.go"
+	header      = "# Code generated by pkg/crd/generate.go. DO NOT EDIT.\n"
+
+	markerPrefix   = "+validation:"
+	optionalMarker = "+optional"
+)
+
+type printerColumn struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Format      string `json:"format,omitempty"`
+	Description string `json:"description,omitempty"`
+	JSONPath    string `json:"JSONPath"`
+}
+
+type definition struct {
+	file           string
+	kind           string
+	plural         string
+	status         bool
+	printerColumns []printerColumn
+}
+
+var definitions = []definition{
+	{
+		file:   "cluster_crd.yml",
+		kind:   "Cluster",
+		plural: "clusters",
+		status: true,
+		printerColumns: []printerColumn{
+			conditionColumn("Provisioned"),
+			conditionColumn("Ready"),
+			{
+				Name:        "Version",
+				Type:        "string",
+				Description: "Kubernetes version reported by the cluster",
+				JSONPath:    `.metadata.annotations.clusterprovisioner\.rke\.io/kubernetes-version`,
+			},
+			ageColumn(),
+		},
+	},
+	{
+		file:   "kubeconfig_crd.yml",
+		kind:   "Kubeconfig",
+		plural: "kubeconfigs",
+		printerColumns: []printerColumn{
+			{
+				Name:        "Secret",
+				Type:        "string",
+				Description: "Secret holding the kubeconfig",
+				JSONPath:    ".spec.secretRef.name",
+			},
+			ageColumn(),
+		},
+	},
+}
+
+// external holds the schemas of the types the API uses from other packages
+var external = map[string]apiextensionsv1beta1.JSONSchemaProps{
+	"v1.SecretReference": {
+		Type:     "object",
+		Required: []string{"name"},
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{
+			"name":      {Type: "string", MinLength: int64Ptr(1)},
+			"namespace": {Type: "string"},
+		},
+	},
+	"v1.ConditionStatus": {
+		Type: "string",
+		Enum: enum("True", "False", "Unknown"),
+	},
+	"metav1.Time": {
+		Type:   "string",
+		Format: "date-time",
+	},
+	"metav1.Duration": {
+		Type:    "string",
+		Pattern: `^([0-9]+(\.[0-9]+)?(ns|us|ms|s|m|h))+$`,
+	},
+}
+
+func conditionColumn(cond string) printerColumn {
+	return printerColumn{
+		Name:        cond,
+		Type:        "string",
+		Description: fmt.Sprintf("Status of the %s condition", cond),
+		JSONPath:    fmt.Sprintf(`.status.conditions[?(@.type=="%s")].status`, cond),
+	}
+}
+
+func ageColumn() printerColumn {
+	return printerColumn{
+		Name:     "Age",
+		Type:     "date",
+		JSONPath: ".metadata.creationTimestamp",
+	}
+}
+
+func main() {
+	g, err := newGenerator(typesDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, "// Code generated by generate.go. DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package crd")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// definitions holds the CRD manifests in YAML, keyed by file name")
+	fmt.Fprintln(&buf, "var definitions = map[string]string{")
+	for _, def := range definitions {
+		manifest, err := g.manifest(def)
+		if err != nil {
+			log.Fatalf("%s: %v", def.kind, err)
+		}
+		if strings.Contains(manifest, "`") {
+			log.Fatalf("%s manifest contains a backtick", def.kind)
+		}
+		if err := ioutil.WriteFile(filepath.Join(manifestDir, def.file), []byte(manifest), 0644); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(&buf, "%q: `%s`,\n", def.file, manifest)
+	}
+	fmt.Fprintln(&buf, "}")
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile(outputFile, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+type generator struct {
+	types map[string]*ast.TypeSpec
+	docs  map[string]*ast.CommentGroup
+}
+
+func newGenerator(dir string) (*generator, error) {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), dir, func(info os.FileInfo) bool {
+		return !strings.HasPrefix(info.Name(), "zz_generated")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	g := &generator{
+		types: map[string]*ast.TypeSpec{},
+		docs:  map[string]*ast.CommentGroup{},
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				genDecl, ok := decl.(*ast.GenDecl)
+				if !ok || genDecl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range genDecl.Specs {
+					typeSpec := spec.(*ast.TypeSpec)
+					g.types[typeSpec.Name.Name] = typeSpec
+					g.docs[typeSpec.Name.Name] = typeSpec.Doc
+					if typeSpec.Doc == nil {
+						g.docs[typeSpec.Name.Name] = genDecl.Doc
+					}
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+func (g *generator) manifest(def definition) (string, error) {
+	schema, err := g.schema(ast.NewIdent(def.kind), nil)
+	if err != nil {
+		return "", err
+	}
+	spec := map[string]interface{}{
+		"group":   group,
+		"version": version,
+		"scope":   "Cluster",
+		"names": map[string]interface{}{
+			"kind":   def.kind,
+			"plural": def.plural,
+		},
+		"validation": map[string]interface{}{
+			"openAPIV3Schema": schema,
+		},
+		"additionalPrinterColumns": def.printerColumns,
+	}
+	if def.status {
+		spec["subresources"] = map[string]interface{}{
+			"status": map[string]interface{}{},
+		}
+	}
+	crd := map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1beta1",
+		"kind":       "CustomResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name": def.plural + "." + group,
+		},
+		"spec": spec,
+	}
+	content, err := json.Marshal(crd)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := yaml.JSONToYAML(content)
+	if err != nil {
+		return "", err
+	}
+	return header + string(manifest), nil
+}
+
+// schema returns the schema of the type expression, refined by the markers of
+// the field it's declared by
+func (g *generator) schema(expr ast.Expr, markers map[string]string) (apiextensionsv1beta1.JSONSchemaProps, error) {
+	var schema apiextensionsv1beta1.JSONSchemaProps
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return g.schema(t.X, markers)
+	case *ast.ArrayType:
+		items, err := g.schema(t.Elt, nil)
+		if err != nil {
+			return schema, err
+		}
+		if values, ok := markers["Enum"]; ok {
+			items.Enum = enum(strings.Split(values, ";")...)
+			delete(markers, "Enum")
+		}
+		schema.Type = "array"
+		schema.Items = &apiextensionsv1beta1.JSONSchemaPropsOrArray{Schema: &items}
+	case *ast.MapType:
+		values, err := g.schema(t.Value, nil)
+		if err != nil {
+			return schema, err
+		}
+		schema.Type = "object"
+		schema.AdditionalProperties = &apiextensionsv1beta1.JSONSchemaPropsOrBool{Allows: true, Schema: &values}
+	case *ast.SelectorExpr:
+		name := fmt.Sprintf("%s.%s", t.X.(*ast.Ident).Name, t.Sel.Name)
+		s, ok := external[name]
+		if !ok {
+			return schema, fmt.Errorf("no schema for external type %s", name)
+		}
+		schema = s
+	case *ast.StructType:
+		s, err := g.structSchema(t)
+		if err != nil {
+			return schema, err
+		}
+		schema = s
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			schema.Type = "string"
+		case "bool":
+			schema.Type = "boolean"
+		case "int", "int64":
+			schema.Type = "integer"
+			schema.Format = "int64"
+		case "int32":
+			schema.Type = "integer"
+			schema.Format = "int32"
+		default:
+			typeSpec, ok := g.types[t.Name]
+			if !ok {
+				return schema, fmt.Errorf("unknown type %s", t.Name)
+			}
+			s, err := g.schema(typeSpec.Type, parseMarkers(g.docs[t.Name]))
+			if err != nil {
+				return schema, fmt.Errorf("%s: %v", t.Name, err)
+			}
+			schema = s
+		}
+	default:
+		return schema, fmt.Errorf("unsupported type %T", expr)
+	}
+	return schema, applyMarkers(&schema, markers)
+}
+
+func (g *generator) structSchema(t *ast.StructType) (apiextensionsv1beta1.JSONSchemaProps, error) {
+	schema := apiextensionsv1beta1.JSONSchemaProps{
+		Type:       "object",
+		Properties: map[string]apiextensionsv1beta1.JSONSchemaProps{},
+	}
+	for _, field := range t.Fields.List {
+		name, omitEmpty, inline := jsonTag(field)
+		if name == "-" {
+			continue
+		}
+		if inline {
+			if _, ok := field.Type.(*ast.SelectorExpr); ok {
+				// TypeMeta and ObjectMeta are validated by the apiserver
+				continue
+			}
+			embedded, err := g.schema(field.Type, nil)
+			if err != nil {
+				return schema, err
+			}
+			for property, s := range embedded.Properties {
+				schema.Properties[property] = s
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "metadata" {
+			continue
+		}
+
+		markers := parseMarkers(field.Doc)
+		_, optional := markers[optionalMarker]
+		delete(markers, optionalMarker)
+		property, err := g.schema(field.Type, markers)
+		if err != nil {
+			return schema, fmt.Errorf("field %s: %v", name, err)
+		}
+		if description := description(field.Doc); description != "" {
+			property.Description = description
+		}
+		schema.Properties[name] = property
+		if !omitEmpty && !optional {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema, nil
+}
+
+func jsonTag(field *ast.Field) (name string, omitEmpty, inline bool) {
+	if field.Tag != nil {
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err == nil {
+			parts := strings.Split(reflect.StructTag(tag).Get("json"), ",")
+			name = parts[0]
+			for _, option := range parts[1:] {
+				switch option {
+				case "omitempty":
+					omitEmpty = true
+				case "inline":
+					inline = true
+				}
+			}
+		}
+	}
+	if len(field.Names) == 0 && name == "" {
+		inline = true
+	}
+	if name == "" && len(field.Names) > 0 {
+		name = field.Names[0].Name
+	}
+	return name, omitEmpty, inline
+}
+
+func parseMarkers(doc *ast.CommentGroup) map[string]string {
+	markers := map[string]string{}
+	if doc == nil {
+		return markers
+	}
+	for _, comment := range doc.List {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.Text, "//"))
+		if text == optionalMarker {
+			markers[optionalMarker] = ""
+		} else if strings.HasPrefix(text, markerPrefix) {
+			parts := strings.SplitN(strings.TrimPrefix(text, markerPrefix), "=", 2)
+			if len(parts) == 2 {
+				markers[parts[0]] = parts[1]
+			}
+		}
+	}
+	return markers
+}
+
+func applyMarkers(schema *apiextensionsv1beta1.JSONSchemaProps, markers map[string]string) error {
+	for marker, value := range markers {
+		switch marker {
+		case "Enum":
+			schema.Enum = enum(strings.Split(value, ";")...)
+		case "Format":
+			schema.Format = value
+		case "Pattern":
+			schema.Pattern = value
+		case "MinLength", "MinItems":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s marker %v", marker, err)
+			}
+			if marker == "MinLength" {
+				schema.MinLength = &n
+			} else {
+				schema.MinItems = &n
+			}
+		case "Minimum":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s marker %v", marker, err)
+			}
+			schema.Minimum = &n
+		case "RequireAnyOf":
+			for _, field := range strings.Split(value, ";") {
+				schema.AnyOf = append(schema.AnyOf, apiextensionsv1beta1.JSONSchemaProps{
+					Required: []string{field},
+				})
+			}
+		default:
+			return fmt.Errorf("unknown marker %s", marker)
+		}
+	}
+	return nil
+}
+
+// description joins the comment lines that aren't markers
+func description(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	var lines []string
+	for _, comment := range doc.List {
+		text := strings.TrimSpace(strings.TrimPrefix(comment.Text, "//"))
+		if text == "" || strings.HasPrefix(text, "+") {
+			continue
+		}
+		lines = append(lines, text)
+	}
+	return strings.Join(lines, " ")
+}
+
+func enum(values ...string) []apiextensionsv1beta1.JSON {
+	var result []apiextensionsv1beta1.JSON
+	for _, value := range values {
+		result = append(result, apiextensionsv1beta1.JSON{Raw: []byte(strconv.Quote(value))})
+	}
+	return result
+}
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
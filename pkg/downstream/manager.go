@@ -0,0 +1,152 @@
+package downstream
+
+import (
+	"sync"
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	listers "github.com/rancher/kubecon2018/pkg/client/listers/clusterprovisioner/v1alpha1"
+	kubeconfigutil "github.com/rancher/kubecon2018/pkg/kubeconfig"
+	"github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// Options configures the clients of the downstream clusters
+type Options struct {
+	// QPS and Burst rate limit the requests to each cluster, all the clients
+	// of a cluster share its limit
+	QPS   float32
+	Burst int
+	// Timeout bounds the requests of the clients asked for without one
+	Timeout time.Duration
+}
+
+// Manager caches the clients of the downstream clusters, built from their
+// Kubeconfig resources. The clients of a cluster are rebuilt once its
+// Kubeconfig or the secret it references changed, and dropped once either is
+// deleted.
+type Manager struct {
+	kubeconfigLister listers.KubeconfigLister
+	secretLister     corelisters.SecretLister
+	synced           []cache.InformerSynced
+	options          Options
+
+	lock    sync.Mutex
+	entries map[string]*entry
+}
+
+// entry holds the clients of a cluster, by request timeout
+type entry struct {
+	// source identifies the Kubeconfig and secret versions the clients were
+	// built from
+	source      string
+	secretRef   v1.SecretReference
+	rateLimiter flowcontrol.RateLimiter
+	clients     map[time.Duration]kubernetes.Interface
+}
+
+// NewManager returns a manager reading the Kubeconfig resources and their
+// secrets from the given informers
+func NewManager(kubeconfigInformer, secretInformer cache.SharedIndexInformer, options Options) *Manager {
+	m := &Manager{
+		kubeconfigLister: listers.NewKubeconfigLister(kubeconfigInformer.GetIndexer()),
+		secretLister:     corelisters.NewSecretLister(secretInformer.GetIndexer()),
+		synced:           []cache.InformerSynced{kubeconfigInformer.HasSynced, secretInformer.HasSynced},
+		options:          options,
+		entries:          map[string]*entry{},
+	}
+	kubeconfigInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if kubeconfig, ok := obj.(*types.Kubeconfig); ok {
+				m.forget(func(name string, e *entry) bool { return name == kubeconfig.Name })
+			}
+		},
+	})
+	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if secret, ok := obj.(*v1.Secret); ok {
+				m.forget(func(name string, e *entry) bool {
+					return e.secretRef.Namespace == secret.Namespace && e.secretRef.Name == secret.Name
+				})
+			}
+		},
+	})
+	return m
+}
+
+// HasSynced tells whether the caches the clients are built from synced
+func (m *Manager) HasSynced() bool {
+	for _, synced := range m.synced {
+		if !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Client returns the client of the cluster whose requests time out after
+// timeout, the manager default applies when it isn't positive. A not found
+// error is returned until the Kubeconfig of the cluster and its secret
+// exist.
+func (m *Manager) Client(clusterName string, timeout time.Duration) (kubernetes.Interface, error) {
+	if timeout <= 0 {
+		timeout = m.options.Timeout
+	}
+	kubeconfig, secret, err := kubeconfigutil.Lookup(m.kubeconfigLister, m.secretLister, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	source := kubeconfig.ResourceVersion + "/" + secret.ResourceVersion
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	e, ok := m.entries[clusterName]
+	if !ok || e.source != source {
+		if ok {
+			logrus.Debugf("Kubeconfig of cluster [%s] changed, rebuilding its clients", clusterName)
+		}
+		e = &entry{
+			source:      source,
+			secretRef:   kubeconfig.Spec.SecretRef,
+			rateLimiter: flowcontrol.NewTokenBucketRateLimiter(m.options.QPS, m.options.Burst),
+			clients:     map[time.Duration]kubernetes.Interface{},
+		}
+		m.entries[clusterName] = e
+	}
+	if client, ok := e.clients[timeout]; ok {
+		return client, nil
+	}
+
+	restConfig, err := kubeconfigutil.FromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Timeout = timeout
+	restConfig.RateLimiter = e.rateLimiter
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	e.clients[timeout] = client
+	return client, nil
+}
+
+func (m *Manager) forget(match func(name string, e *entry) bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for name, e := range m.entries {
+		if match(name, e) {
+			delete(m.entries, name)
+		}
+	}
+}
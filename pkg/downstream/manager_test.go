@@ -0,0 +1,95 @@
+package downstream
+
+import (
+	"testing"
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	clusterfake "github.com/rancher/kubecon2018/pkg/client/clientset/versioned/fake"
+	informers "github.com/rancher/kubecon2018/pkg/client/informers/externalversions"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	namespace = "cluster-provisioner"
+
+	kubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://test.invalid:6443
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: admin
+current-context: test
+users:
+- name: admin
+  user:
+    token: test
+`
+)
+
+// newTestManager returns a manager whose informer caches are filled by hand
+func newTestManager() (*Manager, cache.Indexer, cache.Indexer) {
+	kubeconfigInformer := informers.NewSharedInformerFactory(clusterfake.NewSimpleClientset(), 0).
+		Clusterprovisioner().V1alpha1().Kubeconfigs().Informer()
+	secretInformer := coreinformers.NewSecretInformer(kubefake.NewSimpleClientset(), namespace, 0, cache.Indexers{})
+	manager := NewManager(kubeconfigInformer, secretInformer, Options{QPS: 10, Burst: 20, Timeout: time.Minute})
+	return manager, kubeconfigInformer.GetIndexer(), secretInformer.GetIndexer()
+}
+
+func newKubeconfig(clusterName string) *types.Kubeconfig {
+	return &types.Kubeconfig{
+		ObjectMeta: metav1.ObjectMeta{Name: clusterName, ResourceVersion: "1"},
+		Spec: types.KubeconfigSpec{
+			SecretRef: v1.SecretReference{Name: clusterName + "-kubeconfig", Namespace: namespace},
+		},
+	}
+}
+
+func newSecret(clusterName, resourceVersion string) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            clusterName + "-kubeconfig",
+			Namespace:       namespace,
+			ResourceVersion: resourceVersion,
+		},
+		Data: map[string][]byte{types.KubeconfigSecretKey: []byte(kubeconfig)},
+	}
+}
+
+func TestClientCache(t *testing.T) {
+	manager, kubeconfigs, secrets := newTestManager()
+	kubeconfigs.Add(newKubeconfig("test"))
+	secrets.Add(newSecret("test", "1"))
+
+	client, err := manager.Client("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := manager.Client("test", time.Minute); err != nil || cached != client {
+		t.Errorf("client of an unchanged secret was rebuilt: %v", err)
+	}
+	if other, err := manager.Client("test", time.Second); err != nil || other == client {
+		t.Errorf("client of another timeout was shared: %v", err)
+	}
+
+	secrets.Update(newSecret("test", "2"))
+	rotated, err := manager.Client("test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated == client {
+		t.Error("client of a rotated secret wasn't rebuilt")
+	}
+	if cached, err := manager.Client("test", 0); err != nil || cached != rotated {
+		t.Errorf("client of the rotated secret was rebuilt again: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package kubeconfig
+
+import (
+	"fmt"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	listers "github.com/rancher/kubecon2018/pkg/client/listers/clusterprovisioner/v1alpha1"
+	"k8s.io/api/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// SecretName returns the name of the secret holding the kubeconfig of the cluster
+func SecretName(clusterName string) string {
+	return fmt.Sprintf("%s-kubeconfig", clusterName)
+}
+
+// Lookup returns the Kubeconfig resource of the cluster and the secret it
+// references. A not found error is returned when either doesn't exist yet.
+func Lookup(kubeconfigLister listers.KubeconfigLister, secretLister corelisters.SecretLister, clusterName string) (*types.Kubeconfig, *v1.Secret, error) {
+	kubeconfig, err := kubeconfigLister.Get(clusterName)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref := kubeconfig.Spec.SecretRef
+	secret, err := secretLister.Secrets(ref.Namespace).Get(ref.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kubeconfig, secret, nil
+}
+
+// FromSecret builds a rest config from the kubeconfig held by secret
+func FromSecret(secret *v1.Secret) (*rest.Config, error) {
+	data, ok := secret.Data[types.KubeconfigSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %s key", secret.Namespace, secret.Name, types.KubeconfigSecretKey)
+	}
+	return FromBytes(data)
+}
+
+// FromBytes builds a rest config from kubeconfig file content
+func FromBytes(data []byte) (*rest.Config, error) {
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+}
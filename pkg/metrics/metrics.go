@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	namespace = "clusterprovisioner"
+
+	// OperationUp is the operation label of provisioning and updating a cluster
+	OperationUp = "up"
+	// OperationRemove is the operation label of tearing down a cluster
+	OperationRemove = "remove"
+	// OperationRestartControlPlane is the operation label of restarting the
+	// control plane of a cluster
+	OperationRestartControlPlane = "restart_controlplane"
+
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+var (
+	provisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provisioning_duration_seconds",
+		Help:      "Duration of provisioner operations by cluster, operation and result",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, []string{"cluster", "operation", "result"})
+
+	rkeExitCodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rke_exits_total",
+		Help:      "Number of rke runs by command and exit code, -1 when rke was killed",
+	}, []string{"command", "code"})
+
+	healthProbeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "health_probe_duration_seconds",
+		Help:      "Latency of cluster health probes by cluster, probe and result",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"cluster", "probe", "result"})
+
+	healthProbeSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "health_probe_success",
+		Help:      "Whether the last run of the health probe succeeded on the cluster",
+	}, []string{"cluster", "probe"})
+
+	kubernetesVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_kubernetes_version_info",
+		Help:      "Kubernetes version discovered on the cluster, always 1",
+	}, []string{"cluster", "version"})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workqueue_depth",
+		Help:      "Current number of items in the queue",
+	}, []string{"queue"})
+
+	queueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workqueue_adds_total",
+		Help:      "Number of items added to the queue",
+	}, []string{"queue"})
+
+	queueLatency = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: namespace,
+		Name:      "workqueue_queue_duration_seconds",
+		Help:      "Time items stay in the queue before being processed",
+	}, []string{"queue"})
+
+	queueWorkDuration = prometheus.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace: namespace,
+		Name:      "workqueue_work_duration_seconds",
+		Help:      "Time processing an item takes",
+	}, []string{"queue"})
+
+	queueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "workqueue_retries_total",
+		Help:      "Number of items requeued after a failure",
+	}, []string{"queue"})
+
+	// versions tracks the version label exported for each cluster, so the
+	// stale series is dropped when it changes
+	versionsLock sync.Mutex
+	versions     = map[string]string{}
+
+	// probes tracks the health probe names observed, so their series can be
+	// dropped with the cluster
+	probesLock sync.Mutex
+	probes     = map[string]bool{}
+)
+
+func init() {
+	prometheus.MustRegister(
+		provisioningDuration,
+		rkeExitCodes,
+		healthProbeDuration,
+		healthProbeSuccess,
+		kubernetesVersion,
+		queueDepth,
+		queueAdds,
+		queueLatency,
+		queueWorkDuration,
+		queueRetries,
+	)
+	workqueue.SetProvider(queueMetricsProvider{})
+}
+
+// ObserveProvisioning records the duration and outcome of a provisioner
+// operation started at start
+func ObserveProvisioning(cluster, operation string, start time.Time, err error) {
+	provisioningDuration.WithLabelValues(cluster, operation, result(err)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRKEExit records the exit code of an rke command
+func ObserveRKEExit(command string, code int) {
+	rkeExitCodes.WithLabelValues(command, strconv.Itoa(code)).Inc()
+}
+
+// ObserveHealthProbe records the latency and result of a health probe started
+// at start
+func ObserveHealthProbe(cluster, probe string, start time.Time, err error) {
+	probesLock.Lock()
+	probes[probe] = true
+	probesLock.Unlock()
+	healthProbeDuration.WithLabelValues(cluster, probe, result(err)).Observe(time.Since(start).Seconds())
+	success := 0.0
+	if err == nil {
+		success = 1
+	}
+	healthProbeSuccess.WithLabelValues(cluster, probe).Set(success)
+}
+
+// SetKubernetesVersion exports the version discovered on the cluster
+func SetKubernetesVersion(cluster, version string) {
+	versionsLock.Lock()
+	defer versionsLock.Unlock()
+	if old, ok := versions[cluster]; ok && old != version {
+		kubernetesVersion.DeleteLabelValues(cluster, old)
+	}
+	versions[cluster] = version
+	kubernetesVersion.WithLabelValues(cluster, version).Set(1)
+}
+
+// ForgetCluster drops the series of a removed cluster
+func ForgetCluster(cluster string) {
+	for _, operation := range []string{OperationUp, OperationRemove, OperationRestartControlPlane} {
+		for _, result := range []string{resultSuccess, resultFailure} {
+			provisioningDuration.DeleteLabelValues(cluster, operation, result)
+		}
+	}
+	probesLock.Lock()
+	for probe := range probes {
+		for _, result := range []string{resultSuccess, resultFailure} {
+			healthProbeDuration.DeleteLabelValues(cluster, probe, result)
+		}
+		healthProbeSuccess.DeleteLabelValues(cluster, probe)
+	}
+	probesLock.Unlock()
+
+	versionsLock.Lock()
+	defer versionsLock.Unlock()
+	if version, ok := versions[cluster]; ok {
+		kubernetesVersion.DeleteLabelValues(cluster, version)
+		delete(versions, cluster)
+	}
+}
+
+func result(err error) string {
+	if err != nil {
+		return resultFailure
+	}
+	return resultSuccess
+}
+
+// queueMetricsProvider exports the metrics of named work queues
+type queueMetricsProvider struct{}
+
+func (queueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return queueDepth.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return queueAdds.WithLabelValues(name)
+}
+
+func (queueMetricsProvider) NewLatencyMetric(name string) workqueue.SummaryMetric {
+	return microseconds{queueLatency.WithLabelValues(name)}
+}
+
+func (queueMetricsProvider) NewWorkDurationMetric(name string) workqueue.SummaryMetric {
+	return microseconds{queueWorkDuration.WithLabelValues(name)}
+}
+
+func (queueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return queueRetries.WithLabelValues(name)
+}
+
+// microseconds converts the microseconds the work queue observes to seconds
+type microseconds struct {
+	summary prometheus.Summary
+}
+
+func (m microseconds) Observe(value float64) {
+	m.summary.Observe(value / 1e6)
+}
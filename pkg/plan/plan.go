@@ -0,0 +1,261 @@
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+)
+
+const (
+	// ApprovedAnnotation holds the ConfigHash of the plan approved to run on
+	// a cluster requiring approval
+	ApprovedAnnotation = "clusterprovisioner.rke.io/approved-plan"
+
+	hashLength = 16
+)
+
+// Hash returns the ConfigHash of a rendered config
+func Hash(config string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(config)))[:hashLength]
+}
+
+// Compute compares the desired rendered config with the applied one. Both are
+// rke cluster.yml content, the applied config is empty for a cluster that was
+// never provisioned.
+func Compute(desired, applied string) *types.ClusterPlan {
+	plan := &types.ClusterPlan{
+		ConfigHash: Hash(desired),
+	}
+	if desired == applied {
+		return plan
+	}
+	to, toErr := rkeconfig.Parse(desired)
+	from, fromErr := rkeconfig.Parse(applied)
+	if toErr != nil || fromErr != nil {
+		// nothing is known about the change, assume the worst
+		plan.Changes = []types.PlanChange{{
+			Type:       types.PlanChangeConfigChanged,
+			Disruptive: applied != "",
+		}}
+		plan.Disruptive = applied != ""
+		return plan
+	}
+
+	plan.Changes = append(plan.Changes, nodeChanges(from.Nodes, to.Nodes)...)
+	if from.KubernetesVersion != to.KubernetesVersion {
+		plan.Changes = append(plan.Changes, types.PlanChange{
+			Type:       types.PlanChangeKubernetesVersionChanged,
+			From:       from.KubernetesVersion,
+			To:         to.KubernetesVersion,
+			Disruptive: applied != "",
+		})
+	}
+	if from.Network.Plugin != to.Network.Plugin {
+		plan.Changes = append(plan.Changes, types.PlanChange{
+			Type:       types.PlanChangeNetworkChanged,
+			Target:     "plugin",
+			From:       from.Network.Plugin,
+			To:         to.Network.Plugin,
+			Disruptive: applied != "",
+		})
+	} else if !reflect.DeepEqual(from.Network.Options, to.Network.Options) {
+		plan.Changes = append(plan.Changes, types.PlanChange{
+			Type:   types.PlanChangeNetworkChanged,
+			Target: "options",
+			From:   formatMap(from.Network.Options),
+			To:     formatMap(to.Network.Options),
+		})
+	}
+	plan.Changes = append(plan.Changes, serviceChanges(from.Services, to.Services)...)
+	if len(plan.Changes) == 0 {
+		// only formatting or settings the operator doesn't know about differ
+		plan.Changes = append(plan.Changes, types.PlanChange{Type: types.PlanChangeConfigChanged})
+	}
+
+	sortChanges(plan.Changes)
+	for _, change := range plan.Changes {
+		if change.Disruptive {
+			plan.Disruptive = true
+		}
+	}
+	return plan
+}
+
+// ForCluster computes the plan of the next provisioner run of the cluster:
+// the changes of its rendered config and of the hash of the SSH keys it
+// references, against what was last applied
+func ForCluster(cluster *types.Cluster, config, keys string) *types.ClusterPlan {
+	plan := Compute(config, cluster.Status.AppliedConfig)
+	if keys != cluster.Status.AppliedSSHKeys {
+		plan.Changes = append(plan.Changes, types.PlanChange{
+			Type: types.PlanChangeSSHKeysChanged,
+			From: cluster.Status.AppliedSSHKeys,
+			To:   keys,
+		})
+		sortChanges(plan.Changes)
+	}
+	return plan
+}
+
+// Approved tells whether the changes of the plan may run on the cluster
+func Approved(cluster *types.Cluster, plan *types.ClusterPlan) bool {
+	if !cluster.Spec.RequireApproval || !plan.Disruptive {
+		return true
+	}
+	return cluster.Annotations[ApprovedAnnotation] == plan.ConfigHash
+}
+
+// Summary returns a one line description of the plan
+func Summary(plan *types.ClusterPlan) string {
+	counts := map[types.PlanChangeType]int{}
+	var order []types.PlanChangeType
+	for _, change := range plan.Changes {
+		if counts[change.Type] == 0 {
+			order = append(order, change.Type)
+		}
+		counts[change.Type]++
+	}
+	if len(order) == 0 {
+		return "no changes"
+	}
+	var parts []string
+	for _, changeType := range order {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[changeType], changeType))
+	}
+	summary := strings.Join(parts, ", ")
+	if plan.Disruptive {
+		summary += " (disruptive)"
+	}
+	return summary
+}
+
+// Print writes the plan as a diff, one change per line
+func Print(w io.Writer, plan *types.ClusterPlan) error {
+	if len(plan.Changes) == 0 {
+		_, err := fmt.Fprintf(w, "No changes, config %s is applied\n", plan.ConfigHash)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Plan for config %s: %s\n", plan.ConfigHash, Summary(plan)); err != nil {
+		return err
+	}
+	for _, change := range plan.Changes {
+		prefix := " "
+		if change.Disruptive {
+			prefix = "!"
+		}
+		line := fmt.Sprintf("%s %s", prefix, change.Type)
+		if change.Target != "" {
+			line += " " + change.Target
+		}
+		if change.From != "" || change.To != "" {
+			line += fmt.Sprintf(": %q -> %q", change.From, change.To)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortChanges(changes []types.PlanChange) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Type != changes[j].Type {
+			return changes[i].Type < changes[j].Type
+		}
+		return changes[i].Target < changes[j].Target
+	})
+}
+
+func nodeChanges(from, to []types.RKEConfigNode) []types.PlanChange {
+	var changes []types.PlanChange
+	applied := map[string]types.RKEConfigNode{}
+	for _, node := range from {
+		applied[node.Address] = node
+	}
+	desired := map[string]bool{}
+	for _, node := range to {
+		desired[node.Address] = true
+		old, ok := applied[node.Address]
+		if !ok {
+			changes = append(changes, types.PlanChange{
+				Type:   types.PlanChangeNodeAdded,
+				Target: node.Address,
+				To:     formatRoles(node.Role),
+			})
+			continue
+		}
+		if formatRoles(old.Role) != formatRoles(node.Role) {
+			changes = append(changes, types.PlanChange{
+				Type:       types.PlanChangeRolesChanged,
+				Target:     node.Address,
+				From:       formatRoles(old.Role),
+				To:         formatRoles(node.Role),
+				Disruptive: true,
+			})
+		}
+	}
+	for _, node := range from {
+		if !desired[node.Address] {
+			changes = append(changes, types.PlanChange{
+				Type:       types.PlanChangeNodeRemoved,
+				Target:     node.Address,
+				From:       formatRoles(node.Role),
+				Disruptive: true,
+			})
+		}
+	}
+	return changes
+}
+
+func serviceChanges(from, to types.RKEConfigServices) []types.PlanChange {
+	var changes []types.PlanChange
+	fromValue, toValue := reflect.ValueOf(from), reflect.ValueOf(to)
+	for i := 0; i < fromValue.NumField(); i++ {
+		if reflect.DeepEqual(fromValue.Field(i).Interface(), toValue.Field(i).Interface()) {
+			continue
+		}
+		// services are named as in cluster.yml
+		name := strings.Split(fromValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		changes = append(changes, types.PlanChange{
+			Type:   types.PlanChangeServiceChanged,
+			Target: name,
+			From:   formatService(fromValue.Field(i).Interface()),
+			To:     formatService(toValue.Field(i).Interface()),
+		})
+	}
+	return changes
+}
+
+func formatRoles(roles []string) string {
+	sorted := append([]string(nil), roles...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func formatService(service interface{}) string {
+	b, err := json.Marshal(service)
+	if err != nil || string(b) == "{}" {
+		return ""
+	}
+	return string(b)
+}
+
+func formatMap(values map[string]string) string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		parts = append(parts, key+"="+values[key])
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,186 @@
+package plan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+)
+
+const baseConfig = `nodes:
+- address: 10.0.0.1
+  user: ubuntu
+  role: [etcd, controlplane]
+- address: 10.0.0.2
+  user: ubuntu
+  role: [worker]
+kubernetes_version: v1.10.1
+`
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name       string
+		desired    string
+		applied    string
+		changes    []types.PlanChange
+		disruptive bool
+	}{
+		{
+			name:    "unchanged",
+			desired: baseConfig,
+			applied: baseConfig,
+		},
+		{
+			name: "node added",
+			desired: `nodes:
+- address: 10.0.0.1
+  user: ubuntu
+  role: [etcd, controlplane]
+- address: 10.0.0.2
+  user: ubuntu
+  role: [worker]
+- address: 10.0.0.3
+  role: [worker]
+kubernetes_version: v1.10.1
+`,
+			applied: baseConfig,
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeNodeAdded, Target: "10.0.0.3", To: "worker"},
+			},
+		},
+		{
+			name: "node removed",
+			desired: `nodes:
+- address: 10.0.0.1
+  user: ubuntu
+  role: [etcd, controlplane]
+kubernetes_version: v1.10.1
+`,
+			applied: baseConfig,
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeNodeRemoved, Target: "10.0.0.2", From: "worker", Disruptive: true},
+			},
+			disruptive: true,
+		},
+		{
+			name: "roles changed",
+			desired: `nodes:
+- address: 10.0.0.1
+  user: ubuntu
+  role: [controlplane, etcd, worker]
+- address: 10.0.0.2
+  user: ubuntu
+  role: [worker]
+kubernetes_version: v1.10.1
+`,
+			applied: baseConfig,
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeRolesChanged, Target: "10.0.0.1", From: "controlplane,etcd", To: "controlplane,etcd,worker", Disruptive: true},
+			},
+			disruptive: true,
+		},
+		{
+			name:    "version on first provisioning",
+			desired: "nodes:\n- address: 10.0.0.1\n  role: [etcd]\nkubernetes_version: v1.10.1\n",
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeKubernetesVersionChanged, To: "v1.10.1"},
+				{Type: types.PlanChangeNodeAdded, Target: "10.0.0.1", To: "etcd"},
+			},
+		},
+		{
+			name:    "version upgrade",
+			desired: strings.Replace(baseConfig, "v1.10.1", "v1.11.0", 1),
+			applied: baseConfig,
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeKubernetesVersionChanged, From: "v1.10.1", To: "v1.11.0", Disruptive: true},
+			},
+			disruptive: true,
+		},
+		{
+			name:    "unparseable desired config",
+			desired: "nodes: [",
+			applied: baseConfig,
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeConfigChanged, Disruptive: true},
+			},
+			disruptive: true,
+		},
+		{
+			name:    "unparseable config on first provisioning",
+			desired: "nodes: [",
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeConfigChanged},
+			},
+		},
+		{
+			name: "formatting only",
+			desired: `# reordered, same settings
+kubernetes_version: v1.10.1
+nodes:
+- {address: 10.0.0.1, user: ubuntu, role: [controlplane, etcd]}
+- {address: 10.0.0.2, user: ubuntu, role: [worker]}
+`,
+			applied: baseConfig,
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeConfigChanged},
+			},
+		},
+	}
+	for _, test := range tests {
+		plan := Compute(test.desired, test.applied)
+		if plan.ConfigHash != Hash(test.desired) {
+			t.Errorf("%s: config hash %s, want %s", test.name, plan.ConfigHash, Hash(test.desired))
+		}
+		if !reflect.DeepEqual(plan.Changes, test.changes) {
+			t.Errorf("%s: changes %+v, want %+v", test.name, plan.Changes, test.changes)
+		}
+		if plan.Disruptive != test.disruptive {
+			t.Errorf("%s: disruptive %v, want %v", test.name, plan.Disruptive, test.disruptive)
+		}
+	}
+}
+
+func TestForCluster(t *testing.T) {
+	applied := &types.Cluster{
+		Status: types.ClusterStatus{
+			AppliedConfig:  baseConfig,
+			AppliedSSHKeys: "old",
+		},
+	}
+	tests := []struct {
+		name    string
+		config  string
+		keys    string
+		changes []types.PlanChange
+	}{
+		{
+			name:   "unchanged",
+			config: baseConfig,
+			keys:   "old",
+		},
+		{
+			name:   "keys rotated",
+			config: baseConfig,
+			keys:   "new",
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeSSHKeysChanged, From: "old", To: "new"},
+			},
+		},
+		{
+			name:   "keys rotated with a version upgrade",
+			config: strings.Replace(baseConfig, "v1.10.1", "v1.11.0", 1),
+			keys:   "new",
+			changes: []types.PlanChange{
+				{Type: types.PlanChangeKubernetesVersionChanged, From: "v1.10.1", To: "v1.11.0", Disruptive: true},
+				{Type: types.PlanChangeSSHKeysChanged, From: "old", To: "new"},
+			},
+		},
+	}
+	for _, test := range tests {
+		plan := ForCluster(applied, test.config, test.keys)
+		if !reflect.DeepEqual(plan.Changes, test.changes) {
+			t.Errorf("%s: changes %+v, want %+v", test.name, plan.Changes, test.changes)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+)
+
+const (
+	// Name the fake provisioner is registered with
+	Name = "fake"
+	// FailAnnotation makes the named operation (up, remove, restart or
+	// validate) fail
+	// for the annotated cluster
+	FailAnnotation = "fake.clusterprovisioner.rke.io/fail"
+
+	kubeConfigTemplate = `apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: https://%[1]s.fake.invalid:6443
+    insecure-skip-tls-verify: true
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: kube-admin-%[1]s
+current-context: %[1]s
+users:
+- name: kube-admin-%[1]s
+  user:
+    token: fake
+`
+)
+
+// Provisioner is an in-memory provisioner for tests and demos. It never
+// touches real infrastructure and always behaves the same way for the same
+// input: operations succeed unless the cluster carries FailAnnotation.
+type Provisioner struct {
+	sync.Mutex
+	clusters map[string]int
+	restarts map[string]int
+}
+
+// NewProvisioner returns an empty fake provisioner
+func NewProvisioner() *Provisioner {
+	return &Provisioner{
+		clusters: map[string]int{},
+		restarts: map[string]int{},
+	}
+}
+
+func (p *Provisioner) Up(ctx context.Context, cluster *types.Cluster) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := fail(cluster, "up"); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.clusters[cluster.Name]++
+	return nil
+}
+
+func (p *Provisioner) Remove(ctx context.Context, cluster *types.Cluster) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := fail(cluster, "remove"); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	delete(p.clusters, cluster.Name)
+	delete(p.restarts, cluster.Name)
+	return nil
+}
+
+func (p *Provisioner) RestartControlPlane(ctx context.Context, cluster *types.Cluster) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := fail(cluster, "restart"); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.clusters[cluster.Name]; !ok {
+		return fmt.Errorf("cluster %s is not provisioned", cluster.Name)
+	}
+	p.restarts[cluster.Name]++
+	return nil
+}
+
+func (p *Provisioner) Validate(cluster *types.Cluster) error {
+	if err := fail(cluster, "validate"); err != nil {
+		return err
+	}
+	return rkeconfig.Validate(cluster)
+}
+
+// KubeConfig derives the kubeconfig from the cluster name alone, so it
+// survives operator restarts like the one of a real backend
+func (p *Provisioner) KubeConfig(cluster *types.Cluster) (string, error) {
+	return fmt.Sprintf(kubeConfigTemplate, cluster.Name), nil
+}
+
+// Clusters returns the sorted names of the clusters currently provisioned
+func (p *Provisioner) Clusters() []string {
+	p.Lock()
+	defer p.Unlock()
+	var names []string
+	for name := range p.clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UpCount returns how many times Up succeeded for the cluster since it was
+// last removed
+func (p *Provisioner) UpCount(name string) int {
+	p.Lock()
+	defer p.Unlock()
+	return p.clusters[name]
+}
+
+// RestartCount returns how many times the control plane of the cluster was
+// restarted since it was last removed
+func (p *Provisioner) RestartCount(name string) int {
+	p.Lock()
+	defer p.Unlock()
+	return p.restarts[name]
+}
+
+func fail(cluster *types.Cluster, op string) error {
+	if cluster.Annotations[FailAnnotation] == op {
+		return fmt.Errorf("fake %s failure for cluster %s", op, cluster.Name)
+	}
+	return nil
+}
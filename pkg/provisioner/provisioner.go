@@ -0,0 +1,79 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+)
+
+const (
+	// DefaultProvisioner is used for clusters that don't set Spec.Provisioner
+	DefaultProvisioner = "rke"
+)
+
+// Provisioner is the backend that creates, updates and removes the
+// infrastructure behind a Cluster resource.
+type Provisioner interface {
+	// Up provisions the cluster, or updates it to match the spec when it
+	// already exists. The operation is aborted when ctx is done.
+	Up(ctx context.Context, cluster *types.Cluster) error
+	// Remove tears down the cluster. The operation is aborted when ctx is done.
+	Remove(ctx context.Context, cluster *types.Cluster) error
+	// RestartControlPlane restarts the control plane components of a
+	// provisioned cluster. The operation is aborted when ctx is done.
+	RestartControlPlane(ctx context.Context, cluster *types.Cluster) error
+	// Validate checks that the cluster spec can be handled by the backend
+	Validate(cluster *types.Cluster) error
+	// KubeConfig returns the admin kubeconfig of a provisioned cluster
+	KubeConfig(cluster *types.Cluster) (string, error)
+}
+
+var (
+	lock         sync.RWMutex
+	provisioners = map[string]Provisioner{}
+)
+
+// Register makes a provisioner available by the given name. Registering the
+// same name twice replaces the previous provisioner.
+func Register(name string, provisioner Provisioner) {
+	lock.Lock()
+	defer lock.Unlock()
+	provisioners[name] = provisioner
+}
+
+// Get returns the provisioner registered by the given name
+func Get(name string) (Provisioner, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	provisioner, ok := provisioners[name]
+	if !ok {
+		return nil, fmt.Errorf("provisioner [%s] is not registered, available: %v", name, names())
+	}
+	return provisioner, nil
+}
+
+// ForCluster returns the provisioner set in the cluster spec, falling back to
+// DefaultProvisioner
+func ForCluster(cluster *types.Cluster) (Provisioner, error) {
+	return Get(Name(cluster))
+}
+
+// Name returns the name of the provisioner handling the cluster
+func Name(cluster *types.Cluster) string {
+	if cluster.Spec.Provisioner == "" {
+		return DefaultProvisioner
+	}
+	return cluster.Spec.Provisioner
+}
+
+func names() []string {
+	var result []string
+	for name := range provisioners {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
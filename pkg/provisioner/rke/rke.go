@@ -0,0 +1,285 @@
+package rke
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/metrics"
+	"github.com/rancher/kubecon2018/pkg/rkeconfig"
+	"github.com/rancher/kubecon2018/pkg/rkestate"
+	"github.com/rancher/kubecon2018/pkg/runlog"
+	"github.com/rancher/kubecon2018/pkg/sshkeys"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Name the rke provisioner is registered with
+	Name = "rke"
+	// failureTailLines is the number of output lines a failure is reported with
+	failureTailLines = 10
+	// sshBin runs the commands rke has no equivalent for on the nodes
+	sshBin = "ssh"
+)
+
+// controlPlaneContainers are the containers rke runs the control plane
+// components in
+var controlPlaneContainers = []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+
+// Provisioner provisions clusters by invoking the rke binary
+type Provisioner struct {
+	binPath string
+	workDir string
+	// slots limits the number of rke processes running at the same time
+	slots chan struct{}
+	logs  *runlog.Store
+	state *rkestate.Store
+	keys  sshkeys.SecretGetter
+}
+
+// NewProvisioner returns a provisioner running the rke binary found at
+// binPath. A bare binary name is looked up in PATH. Each run happens in a
+// scratch directory under workDir, the files rke needs across runs are
+// restored from state beforehand and saved back after it succeeded. At most
+// maxProcesses rke processes run at the same time, there is no limit when it
+// isn't positive. The output of each run is kept in logs. The SSH keys nodes
+// reference are read through keys, and only exist on disk during the run.
+func NewProvisioner(binPath, workDir string, maxProcesses int, logs *runlog.Store, state *rkestate.Store, keys sshkeys.SecretGetter) *Provisioner {
+	p := &Provisioner{
+		binPath: binPath,
+		workDir: workDir,
+		logs:    logs,
+		state:   state,
+		keys:    keys,
+	}
+	if maxProcesses > 0 {
+		p.slots = make(chan struct{}, maxProcesses)
+	}
+	return p
+}
+
+func (p *Provisioner) Up(ctx context.Context, cluster *types.Cluster) error {
+	return p.run(ctx, cluster, "up")
+}
+
+func (p *Provisioner) Remove(ctx context.Context, cluster *types.Cluster) error {
+	return p.run(ctx, cluster, "remove", "--force")
+}
+
+func (p *Provisioner) Validate(cluster *types.Cluster) error {
+	if _, err := exec.LookPath(p.binPath); err != nil {
+		return fmt.Errorf("rke binary [%s] not found %v", p.binPath, err)
+	}
+	if cluster.Spec.RKEConfig != nil {
+		return rkeconfig.Validate(cluster)
+	}
+	if cluster.Spec.ConfigPath == "" {
+		return fmt.Errorf("neither rkeConfig nor configPath is set")
+	}
+	if _, err := os.Stat(cluster.Spec.ConfigPath); err != nil {
+		return fmt.Errorf("failed to read config %s %v", cluster.Spec.ConfigPath, err)
+	}
+	return nil
+}
+
+func (p *Provisioner) KubeConfig(cluster *types.Cluster) (string, error) {
+	return p.state.KubeConfig(cluster)
+}
+
+// RestartControlPlane restarts the control plane containers of the
+// controlplane nodes over SSH, with the keys rke connects with. The nodes
+// are restarted one at a time, in the order of the config.
+func (p *Provisioner) RestartControlPlane(ctx context.Context, cluster *types.Cluster) error {
+	return p.inScratchDir(ctx, cluster, func(dir string, withKeys *types.Cluster) error {
+		config, err := rkeconfig.Render(withKeys)
+		if err != nil {
+			return err
+		}
+		rkeConfig, err := rkeconfig.Parse(config)
+		if err != nil {
+			return err
+		}
+
+		attempt, err := p.logs.Create(cluster.Name, failureTailLines)
+		if err != nil {
+			return fmt.Errorf("failed to create rke log %v", err)
+		}
+		logrus.Infof("Restarting control plane of cluster [%s], output in %s", cluster.Name, attempt.Path())
+		err = restartControlPlane(ctx, rkeConfig, attempt)
+		if finishErr := attempt.Finish(err); finishErr != nil {
+			logrus.Errorf("Failed to close rke log %s %v", attempt.Path(), finishErr)
+		}
+		if err != nil {
+			return fmt.Errorf("control plane restart failed: %v, attempt %d ended with:\n%s", err, attempt.Number, attempt.Tail())
+		}
+		return nil
+	})
+}
+
+// run executes rke once a process slot is free
+func (p *Provisioner) run(ctx context.Context, cluster *types.Cluster, cmdArgs ...string) error {
+	return p.inScratchDir(ctx, cluster, func(dir string, withKeys *types.Cluster) error {
+		configPath, err := rkeconfig.Write(dir, withKeys)
+		if err != nil {
+			return err
+		}
+		if err := p.state.Restore(cluster, dir); err != nil {
+			return fmt.Errorf("failed to restore rke state %v", err)
+		}
+		cmdArgs = append(cmdArgs, "--config", configPath)
+
+		attempt, err := p.logs.Create(cluster.Name, failureTailLines)
+		if err != nil {
+			return fmt.Errorf("failed to create rke log %v", err)
+		}
+		logrus.Infof("Running rke %s for cluster [%s], output in %s", cmdArgs[0], cluster.Name, attempt.Path())
+		err = executeCommand(ctx, p.binPath, cmdArgs, attempt)
+		if code, ok := exitCode(err); ok {
+			metrics.ObserveRKEExit(cmdArgs[0], code)
+		}
+		err = killed(ctx, err)
+		if finishErr := attempt.Finish(err); finishErr != nil {
+			logrus.Errorf("Failed to close rke log %s %v", attempt.Path(), finishErr)
+		}
+		if err != nil {
+			return fmt.Errorf("rke %s failed: %v, attempt %d ended with:\n%s", cmdArgs[0], err, attempt.Number, attempt.Tail())
+		}
+		// the state of a removed cluster goes away with the cluster
+		if cmdArgs[0] == "up" {
+			if err := p.state.Save(cluster, dir); err != nil {
+				return fmt.Errorf("failed to save rke state %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// inScratchDir calls fn once a process slot is free, with a scratch
+// directory and a copy of the cluster whose nodes use the SSH keys written
+// there. The directory is removed once fn returns.
+func (p *Provisioner) inScratchDir(ctx context.Context, cluster *types.Cluster, fn func(dir string, withKeys *types.Cluster) error) error {
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			logrus.Infof("Cluster [%s] is waiting for a free rke process slot", cluster.Name)
+			select {
+			case p.slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		defer func() { <-p.slots }()
+	}
+
+	if err := os.MkdirAll(p.workDir, 0700); err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir(p.workDir, cluster.Name+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create scratch dir %v", err)
+	}
+	defer os.RemoveAll(dir)
+	withKeys, err := sshkeys.Materialize(p.keys, cluster, filepath.Join(dir, "ssh"))
+	if err != nil {
+		return err
+	}
+	return fn(dir, withKeys)
+}
+
+// restartControlPlane restarts the control plane containers node by node,
+// stopping at the first failure
+func restartControlPlane(ctx context.Context, config *types.RKEConfig, output io.Writer) error {
+	restarted := 0
+	for _, node := range config.Nodes {
+		if !hasRole(node, "controlplane") {
+			continue
+		}
+		fmt.Fprintf(output, "Restarting %v on %s\n", controlPlaneContainers, node.Address)
+		args := []string{"-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=no"}
+		if node.SSHKeyPath != "" {
+			args = append(args, "-i", node.SSHKeyPath)
+		}
+		// without a user, ssh connects as the operator user
+		host := node.Address
+		if node.User != "" {
+			host = node.User + "@" + host
+		}
+		args = append(append(args, host, "docker", "restart"), controlPlaneContainers...)
+		if err := killed(ctx, executeCommand(ctx, sshBin, args, output)); err != nil {
+			return fmt.Errorf("node %s %v", node.Address, err)
+		}
+		restarted++
+	}
+	if restarted == 0 {
+		return fmt.Errorf("no controlplane node in the config")
+	}
+	return nil
+}
+
+func hasRole(node types.RKEConfigNode, role string) bool {
+	for _, r := range node.Role {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// executeCommand runs the command writing its stdout and stderr to output.
+// The command gets its own process group, which is killed as a whole if ctx
+// is done before it exits, so the ssh sessions rke spawns don't linger. The
+// error is the one of the command, see killed.
+func executeCommand(ctx context.Context, cmdName string, cmdArgs []string, output io.Writer) error {
+	cmd := exec.Command(cmdName, cmdArgs...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// the same writer for both makes exec serialize the writes
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting cmd '%v' %v", cmd, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	return err
+}
+
+// killed wraps an error returned by executeCommand to tell the command was
+// killed because ctx is done
+func killed(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("%v, killed: %v", ctx.Err(), err)
+	}
+	return err
+}
+
+// exitCode returns the exit code of a command that ran to completion, -1
+// when it was killed by a signal
+func exitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok {
+		return 0, false
+	}
+	return status.ExitStatus(), true
+}
@@ -0,0 +1,93 @@
+package remediation
+
+import (
+	"fmt"
+	"time"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Annotation requests the provisioner to run the remediation action it
+	// holds on the cluster, it is removed once the attempt finished
+	Annotation = "clusterprovisioner.rke.io/remediate"
+
+	// TriggerManual is the trigger of the attempts requested by setting the
+	// annotation by hand
+	TriggerManual = "Manual"
+
+	// DefaultCooldown applies to the policies that don't set their own
+	DefaultCooldown = 30 * time.Minute
+
+	// historyLimit is the number of attempts kept in the status
+	historyLimit = 5
+)
+
+// Action returns the action of the remediation policy of the cluster, None
+// when it has no policy or is imported
+func Action(cluster *types.Cluster) types.RemediationAction {
+	policy := cluster.Spec.Remediation
+	if policy == nil || cluster.Spec.Import != nil || policy.Action == "" {
+		return types.RemediationActionNone
+	}
+	return policy.Action
+}
+
+// Cooldown returns the minimum time between two remediations of the cluster
+func Cooldown(cluster *types.Cluster) time.Duration {
+	if policy := cluster.Spec.Remediation; policy != nil && policy.Cooldown != nil && policy.Cooldown.Duration > 0 {
+		return policy.Cooldown.Duration
+	}
+	return DefaultCooldown
+}
+
+// LastAttempt returns the newest remediation attempt of the cluster, nil
+// when it was never remediated
+func LastAttempt(cluster *types.Cluster) *types.RemediationAttempt {
+	attempts := cluster.Status.Remediations
+	if len(attempts) == 0 {
+		return nil
+	}
+	return &attempts[len(attempts)-1]
+}
+
+// Pending returns the attempt the provisioner is yet to finish, nil when
+// there is none
+func Pending(cluster *types.Cluster) *types.RemediationAttempt {
+	last := LastAttempt(cluster)
+	if last == nil || last.FinishedAt != nil {
+		return nil
+	}
+	return last
+}
+
+// Start records a new running attempt of action on the cluster, the pending
+// attempt, if any, is recorded as superseded
+func Start(cluster *types.Cluster, action types.RemediationAction, trigger string) {
+	Finish(cluster, fmt.Errorf("superseded by a %s remediation", action))
+	cluster.Status.Remediations = append(cluster.Status.Remediations, types.RemediationAttempt{
+		Action:    action,
+		Trigger:   trigger,
+		StartedAt: metav1.Now(),
+	})
+	if len(cluster.Status.Remediations) > historyLimit {
+		cluster.Status.Remediations = cluster.Status.Remediations[len(cluster.Status.Remediations)-historyLimit:]
+	}
+}
+
+// Finish records the result of the running attempt of the cluster
+func Finish(cluster *types.Cluster, err error) {
+	attempt := Pending(cluster)
+	if attempt == nil {
+		return
+	}
+	now := metav1.Now()
+	attempt.FinishedAt = &now
+	if err != nil {
+		attempt.Result = types.RemediationResultFailed
+		attempt.Message = err.Error()
+		return
+	}
+	attempt.Result = types.RemediationResultSucceeded
+}
@@ -0,0 +1,87 @@
+package rkeconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	configFileName = "cluster.yml"
+)
+
+var validRoles = map[string]bool{
+	"etcd":         true,
+	"controlplane": true,
+	"worker":       true,
+}
+
+// Render returns the rke cluster.yml content for the cluster. Clusters
+// without an inline RKEConfig fall back to reading the legacy ConfigPath.
+func Render(cluster *types.Cluster) (string, error) {
+	if cluster.Spec.RKEConfig == nil {
+		if cluster.Spec.ConfigPath == "" {
+			return "", fmt.Errorf("neither rkeConfig nor configPath is set")
+		}
+		b, err := ioutil.ReadFile(cluster.Spec.ConfigPath)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	b, err := yaml.Marshal(cluster.Spec.RKEConfig)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Parse reads rendered rke cluster.yml content back, settings the operator
+// doesn't manage are dropped
+func Parse(config string) (*types.RKEConfig, error) {
+	rkeConfig := &types.RKEConfig{}
+	if err := yaml.Unmarshal([]byte(config), rkeConfig); err != nil {
+		return nil, err
+	}
+	return rkeConfig, nil
+}
+
+// Validate checks the inline rke config of the cluster
+func Validate(cluster *types.Cluster) error {
+	config := cluster.Spec.RKEConfig
+	if config == nil {
+		return nil
+	}
+	if len(config.Nodes) == 0 {
+		return fmt.Errorf("rkeConfig has no nodes")
+	}
+	for i, node := range config.Nodes {
+		if node.Address == "" {
+			return fmt.Errorf("node %d has no address", i)
+		}
+		if len(node.Role) == 0 {
+			return fmt.Errorf("node %s has no roles", node.Address)
+		}
+		for _, role := range node.Role {
+			if !validRoles[role] {
+				return fmt.Errorf("node %s has invalid role %s", node.Address, role)
+			}
+		}
+	}
+	return nil
+}
+
+// Write renders the cluster config to cluster.yml in dir and returns its
+// path. The legacy ConfigPath is copied, so rke writes its state files to dir
+// rather than next to it.
+func Write(dir string, cluster *types.Cluster) (string, error) {
+	config, err := Render(cluster)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, configFileName)
+	return path, ioutil.WriteFile(path, []byte(config), 0600)
+}
@@ -0,0 +1,130 @@
+package rkestate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// StateKey is the key of the rke state file in the secret
+	StateKey = "cluster.rkestate"
+	// KubeConfigKey is the key of the kubeconfig rke generated in the secret
+	KubeConfigKey = "kube_config_cluster.yml"
+)
+
+// files are the secret keys, they double as the file names rke reads and
+// writes next to cluster.yml
+var files = []string{StateKey, KubeConfigKey}
+
+// SecretName returns the name of the secret holding the rke state of the
+// cluster
+func SecretName(clusterName string) string {
+	return fmt.Sprintf("%s-rke-state", clusterName)
+}
+
+// Store keeps the files rke needs across runs in a secret owned by the
+// cluster, so they outlive the operator pod
+type Store struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+// NewStore returns a store keeping the secrets in namespace
+func NewStore(client kubernetes.Interface, namespace string) *Store {
+	return &Store{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// Restore writes the stored files of the cluster into dir, nothing is
+// written for a cluster that was never provisioned
+func (s *Store) Restore(cluster *types.Cluster, dir string) error {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(SecretName(cluster.Name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, file := range files {
+		content, ok := secret.Data[file]
+		if !ok {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, file), content, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save stores the files rke left in dir, the ones missing are kept as they
+// were
+func (s *Store) Save(cluster *types.Cluster, dir string) error {
+	data := map[string][]byte{}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		data[file] = content
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	secret, err := secrets.Get(SecretName(cluster.Name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{clusterutil.OwnerReference(cluster)},
+				Name:            SecretName(cluster.Name),
+				Namespace:       s.namespace,
+			},
+			Type: v1.SecretTypeOpaque,
+			Data: data,
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	toUpdate := secret.DeepCopy()
+	if toUpdate.Data == nil {
+		toUpdate.Data = map[string][]byte{}
+	}
+	for file, content := range data {
+		toUpdate.Data[file] = content
+	}
+	if !clusterutil.IsOwner(cluster, toUpdate.OwnerReferences) {
+		// the state was orphaned by a previous cluster of the same name
+		toUpdate.OwnerReferences = append(toUpdate.OwnerReferences, clusterutil.OwnerReference(cluster))
+	}
+	_, err = secrets.Update(toUpdate)
+	return err
+}
+
+// KubeConfig returns the kubeconfig rke generated for the cluster
+func (s *Store) KubeConfig(cluster *types.Cluster) (string, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(SecretName(cluster.Name), metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	content, ok := secret.Data[KubeConfigKey]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no %s key", s.namespace, secret.Name, KubeConfigKey)
+	}
+	return string(content), nil
+}
@@ -0,0 +1,64 @@
+package rkestate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"github.com/rancher/kubecon2018/pkg/clusterutil"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const namespace = "cluster-provisioner"
+
+func TestSave(t *testing.T) {
+	cluster := &types.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", UID: "new"}}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+	}{
+		{
+			name: "new state",
+		},
+		{
+			name: "orphaned state",
+			objects: []runtime.Object{&v1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: SecretName("test"), Namespace: namespace},
+				Data:       map[string][]byte{StateKey: []byte("old"), KubeConfigKey: []byte("kubeconfig")},
+			}},
+		},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "rkestate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, StateKey), []byte("new"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		client := fake.NewSimpleClientset(test.objects...)
+		if err := NewStore(client, namespace).Save(cluster, dir); err != nil {
+			t.Fatalf("%s: save failed: %v", test.name, err)
+		}
+		secret, err := client.CoreV1().Secrets(namespace).Get(SecretName("test"), metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state := string(secret.Data[StateKey]); state != "new" {
+			t.Errorf("%s: stored state %q, want new", test.name, state)
+		}
+		if !clusterutil.IsOwner(cluster, secret.OwnerReferences) {
+			t.Errorf("%s: state isn't owned by the cluster: %+v", test.name, secret.OwnerReferences)
+		}
+		if len(test.objects) > 0 && string(secret.Data[KubeConfigKey]) != "kubeconfig" {
+			t.Errorf("%s: kubeconfig wasn't kept", test.name)
+		}
+	}
+}
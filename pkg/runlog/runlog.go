@@ -0,0 +1,242 @@
+package runlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logSuffix  = ".log"
+	exitSuffix = ".exit"
+	// maxPartialLine bounds the unterminated line kept for Tail
+	maxPartialLine = 4096
+	followInterval = 500 * time.Millisecond
+)
+
+// Store keeps the output of provisioner runs on disk, one file per cluster
+// and attempt under <dir>/<cluster>/logs. Attempts are numbered from 1, only
+// the latest ones are kept.
+type Store struct {
+	dir  string
+	keep int
+}
+
+// NewStore returns a store rooted at dir keeping the given number of attempts
+// per cluster, all of them when keep isn't positive
+func NewStore(dir string, keep int) *Store {
+	return &Store{
+		dir:  dir,
+		keep: keep,
+	}
+}
+
+func (s *Store) clusterDir(cluster string) string {
+	return filepath.Join(s.dir, cluster, "logs")
+}
+
+// Path returns the log file of the attempt
+func (s *Store) Path(cluster string, attempt int) string {
+	return filepath.Join(s.clusterDir(cluster), strconv.Itoa(attempt)+logSuffix)
+}
+
+func (s *Store) exitPath(cluster string, attempt int) string {
+	return filepath.Join(s.clusterDir(cluster), strconv.Itoa(attempt)+exitSuffix)
+}
+
+// Attempts returns the attempts stored for the cluster in ascending order
+func (s *Store) Attempts(cluster string) ([]int, error) {
+	files, err := ioutil.ReadDir(s.clusterDir(cluster))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var attempts []int
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), logSuffix) {
+			continue
+		}
+		attempt, err := strconv.Atoi(strings.TrimSuffix(file.Name(), logSuffix))
+		if err != nil {
+			continue
+		}
+		attempts = append(attempts, attempt)
+	}
+	sort.Ints(attempts)
+	return attempts, nil
+}
+
+// Latest returns the last attempt of the cluster
+func (s *Store) Latest(cluster string) (int, error) {
+	attempts, err := s.Attempts(cluster)
+	if err != nil {
+		return 0, err
+	}
+	if len(attempts) == 0 {
+		return 0, fmt.Errorf("no logs for cluster %s", cluster)
+	}
+	return attempts[len(attempts)-1], nil
+}
+
+// Result returns the outcome of a finished attempt, false when it is still
+// running or was interrupted
+func (s *Store) Result(cluster string, attempt int) (string, bool) {
+	content, err := ioutil.ReadFile(s.exitPath(cluster, attempt))
+	if err != nil {
+		return "", false
+	}
+	return string(content), true
+}
+
+// Create starts the next attempt of the cluster and removes the attempts
+// exceeding the retention
+func (s *Store) Create(cluster string, tailLines int) (*Attempt, error) {
+	attempts, err := s.Attempts(cluster)
+	if err != nil {
+		return nil, err
+	}
+	number := 1
+	if len(attempts) > 0 {
+		number = attempts[len(attempts)-1] + 1
+	}
+	if err := os.MkdirAll(s.clusterDir(cluster), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(s.Path(cluster, number), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts = append(attempts, number)
+	if s.keep > 0 && len(attempts) > s.keep {
+		for _, old := range attempts[:len(attempts)-s.keep] {
+			os.Remove(s.Path(cluster, old))
+			os.Remove(s.exitPath(cluster, old))
+		}
+	}
+
+	return &Attempt{
+		Number:   number,
+		file:     file,
+		exitPath: s.exitPath(cluster, number),
+		tail:     &tail{max: tailLines},
+	}, nil
+}
+
+// Copy writes the output of the attempt to w. With follow it keeps writing
+// what gets appended until the attempt finished or stop is closed.
+func (s *Store) Copy(w io.Writer, cluster string, attempt int, follow bool, stop <-chan struct{}) error {
+	file, err := os.Open(s.Path(cluster, attempt))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for {
+		// check before copying, so the output written before the attempt
+		// finished is always read
+		_, finished := s.Result(cluster, attempt)
+		if _, err := io.Copy(w, file); err != nil {
+			return err
+		}
+		if !follow || finished {
+			return nil
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+// Attempt is the output of a running attempt, it keeps the last lines written
+// in memory
+type Attempt struct {
+	// Number of the attempt
+	Number   int
+	file     *os.File
+	exitPath string
+	tail     *tail
+}
+
+func (a *Attempt) Write(p []byte) (int, error) {
+	a.tail.Write(p)
+	return a.file.Write(p)
+}
+
+// Path returns the log file of the attempt
+func (a *Attempt) Path() string {
+	return a.file.Name()
+}
+
+// Tail returns the last lines written
+func (a *Attempt) Tail() string {
+	return a.tail.String()
+}
+
+// Finish closes the log file and records the outcome of the attempt
+func (a *Attempt) Finish(runErr error) error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	result := "success"
+	if runErr != nil {
+		result = runErr.Error()
+	}
+	return ioutil.WriteFile(a.exitPath, []byte(result), 0600)
+}
+
+// tail keeps the last max lines written to it
+type tail struct {
+	sync.Mutex
+	max     int
+	lines   []string
+	partial []byte
+}
+
+func (t *tail) Write(p []byte) {
+	t.Lock()
+	defer t.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.add(string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	if len(t.partial) > maxPartialLine {
+		t.add(string(t.partial))
+		t.partial = nil
+	}
+}
+
+func (t *tail) add(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+func (t *tail) String() string {
+	t.Lock()
+	defer t.Unlock()
+	lines := t.lines
+	if len(t.partial) > 0 {
+		lines = append(lines[:len(lines):len(lines)], string(t.partial))
+		if len(lines) > t.max {
+			lines = lines[1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
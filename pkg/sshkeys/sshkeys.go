@@ -0,0 +1,125 @@
+package sshkeys
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	types "github.com/rancher/kubecon2018/pkg/apis/clusterprovisioner/v1alpha1"
+	"k8s.io/api/core/v1"
+)
+
+// SecretGetter returns the secret by name from the operator namespace
+type SecretGetter func(name string) (*v1.Secret, error)
+
+// SecretNames returns the sorted names of the secrets the nodes of the
+// cluster take their SSH key from
+func SecretNames(cluster *types.Cluster) []string {
+	config := cluster.Spec.RKEConfig
+	if config == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, node := range config.Nodes {
+		name := secretName(config, node)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Hash returns a digest of the keys the cluster references, empty when it
+// references none
+func Hash(get SecretGetter, cluster *types.Cluster) (string, error) {
+	names := SecretNames(cluster)
+	if len(names) == 0 {
+		return "", nil
+	}
+	hash := sha256.New()
+	for _, name := range names {
+		key, err := privateKey(get, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00%x\x00", name, sha256.Sum256(key))
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))[:16], nil
+}
+
+// Materialize writes the keys the cluster references to dir, readable by the
+// owner only, and returns a copy of the cluster whose nodes point to them.
+// The caller removes dir once rke is done.
+func Materialize(get SecretGetter, cluster *types.Cluster, dir string) (*types.Cluster, error) {
+	names := SecretNames(cluster)
+	if len(names) == 0 {
+		return cluster, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	paths := map[string]string{}
+	for _, name := range names {
+		key, err := privateKey(get, name)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, key, 0600); err != nil {
+			return nil, err
+		}
+		paths[name] = path
+	}
+
+	result := cluster.DeepCopy()
+	config := result.Spec.RKEConfig
+	for i, node := range config.Nodes {
+		if name := secretName(config, node); name != "" {
+			config.Nodes[i].SSHKeyPath = paths[name]
+		}
+	}
+	return result, nil
+}
+
+// Inherit sets the SSH key secrets of current on config, a config parsed
+// back from rendered cluster.yml doesn't carry them. Nodes are matched by
+// address, the ones current no longer has keep none.
+func Inherit(config, current *types.RKEConfig) {
+	config.SSHKeySecret = current.SSHKeySecret
+	secrets := map[string]string{}
+	for _, node := range current.Nodes {
+		secrets[node.Address] = node.SSHKeySecret
+	}
+	for i, node := range config.Nodes {
+		config.Nodes[i].SSHKeySecret = secrets[node.Address]
+	}
+}
+
+func secretName(config *types.RKEConfig, node types.RKEConfigNode) string {
+	if node.SSHKeySecret != "" {
+		return node.SSHKeySecret
+	}
+	if node.SSHKeyPath != "" {
+		// a node with its own path doesn't use the cluster default
+		return ""
+	}
+	return config.SSHKeySecret
+}
+
+func privateKey(get SecretGetter, name string) ([]byte, error) {
+	secret, err := get(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get SSH key secret %s %v", name, err)
+	}
+	key, ok := secret.Data[v1.SSHAuthPrivateKey]
+	if !ok || len(key) == 0 {
+		return nil, fmt.Errorf("secret %s has no %s key", name, v1.SSHAuthPrivateKey)
+	}
+	return key, nil
+}
@@ -25,4 +25,7 @@ echo Generating informer
 informer-gen --input-dirs ${APIS_VERSION_DIR} \
 	--versioned-clientset-package "${CLIENTSET_DIR}/versioned" \
 	--listers-package ${LISTERS_DIR} \
-	--output-package ${INFORMERS_DIR}
\ No newline at end of file
+	--output-package ${INFORMERS_DIR}
+
+echo Generating CRD definitions
+go generate ./pkg/crd
@@ -1,46 +1,115 @@
 package util
 
 import (
+	"context"
+	"sync"
 	"time"
 
+	"github.com/juju/ratelimit"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
 
-// TaskQueue manages a work queue through an independent worker that
-// invokes the given sync function for every work item inserted.
+// TaskQueue manages a work queue through independent workers that invoke
+// the given sync function for every work item inserted. An item is never
+// processed by two workers at the same time. Failed items
+// are retried with a per item exponential backoff, bounded by an overall rate
+// limit, and parked once they failed maxRetries times in a row.
 type TaskQueue struct {
 	// queue is the work queue the worker polls
-	queue *workqueue.Type
+	queue workqueue.RateLimitingInterface
 	// sync is called for each item in the queue
-	sync func(string)
-	// workerDone is closed when the worker exits
+	sync func(string) error
+	// maxRetries is the number of retries before an item is parked, it's
+	// retried forever when not positive
+	maxRetries int
+	// park is called with the last error when an item gets parked
+	park func(string, error)
+	// parked holds the items not retried until they are unparked
+	parkedLock sync.Mutex
+	parked     map[string]bool
+	// workerDone is closed when all workers exited
 	workerDone chan struct{}
+	// shutdown makes sure the queue is shut down once, the delaying queue
+	// panics otherwise
+	shutdown sync.Once
 }
 
-func (t *TaskQueue) Run(period time.Duration, stopCh <-chan struct{}) {
-	wait.Until(t.worker, period, stopCh)
+// Run starts the given number of workers and blocks until stopCh is closed
+// and the items being processed, if any, are done
+func (t *TaskQueue) Run(workers int, period time.Duration, stopCh <-chan struct{}) {
+	defer close(t.workerDone)
+	go func() {
+		<-stopCh
+		t.shutdownQueue()
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.Until(t.worker, period, stopCh)
+		}()
+	}
+	wg.Wait()
 }
 
-// Enqueue enqueues ns/name of the given api object in the task queue.
+// Enqueue enqueues ns/name of the given api object in the task queue, unless
+// it is parked.
 func (t *TaskQueue) Enqueue(obj interface{}) {
-	if key, ok := obj.(string); ok {
-		t.queue.Add(key)
-	} else {
-		key, err := cache.MetaNamespaceKeyFunc(obj)
-		if err != nil {
-			logrus.Infof("could not get key for object %+v: %v", obj, err)
-			return
-		}
-		t.queue.Add(key)
+	key, ok := t.key(obj)
+	if !ok || t.isParked(key) {
+		return
 	}
+	t.queue.Add(key)
 }
 
-func (t *TaskQueue) Requeue(key string, err error) {
-	logrus.Debugf("requeuing %v, err %v", key, err)
-	t.queue.Add(key)
+// EnqueueAfter enqueues ns/name of the given api object once the duration
+// passed, unless it is parked by then.
+func (t *TaskQueue) EnqueueAfter(obj interface{}, duration time.Duration) {
+	key, ok := t.key(obj)
+	if !ok || t.isParked(key) {
+		return
+	}
+	t.queue.AddAfter(key, duration)
+}
+
+// Retrying tells whether the given api object failed its last sync and waits
+// for its retry
+func (t *TaskQueue) Retrying(obj interface{}) bool {
+	key, ok := t.key(obj)
+	return ok && t.queue.NumRequeues(key) > 0
+}
+
+// Unpark makes a parked object eligible to Enqueue again
+func (t *TaskQueue) Unpark(obj interface{}) {
+	key, ok := t.key(obj)
+	if !ok {
+		return
+	}
+	t.parkedLock.Lock()
+	defer t.parkedLock.Unlock()
+	delete(t.parked, key)
+}
+
+func (t *TaskQueue) isParked(key string) bool {
+	t.parkedLock.Lock()
+	defer t.parkedLock.Unlock()
+	return t.parked[key]
+}
+
+func (t *TaskQueue) key(obj interface{}) (string, bool) {
+	if key, ok := obj.(string); ok {
+		return key, true
+	}
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		logrus.Infof("could not get key for object %+v: %v", obj, err)
+		return "", false
+	}
+	return key, true
 }
 
 // worker processes work in the queue through sync.
@@ -48,27 +117,93 @@ func (t *TaskQueue) worker() {
 	for {
 		key, quit := t.queue.Get()
 		if quit {
-			close(t.workerDone)
 			return
 		}
 		logrus.Debugf("syncing %v", key)
-		t.sync(key.(string))
+		if err := t.sync(key.(string)); err != nil {
+			t.retry(key.(string), err)
+		} else {
+			t.queue.Forget(key)
+		}
 		t.queue.Done(key)
 	}
 }
 
-// Shutdown shuts down the work queue and waits for the worker to ACK
+// retry requeues the failed key after its backoff, or parks it when it ran
+// out of retries
+func (t *TaskQueue) retry(key string, err error) {
+	if t.maxRetries > 0 && t.queue.NumRequeues(key) >= t.maxRetries {
+		logrus.Errorf("giving up on %v after %d retries, err %v", key, t.maxRetries, err)
+		t.queue.Forget(key)
+		t.parkedLock.Lock()
+		t.parked[key] = true
+		t.parkedLock.Unlock()
+		if t.park != nil {
+			t.park(key, err)
+		}
+		return
+	}
+	logrus.Debugf("requeuing %v, err %v", key, err)
+	t.queue.AddRateLimited(key)
+}
+
+// Shutdown shuts down the work queue and waits for the workers started by Run
+// to ACK
 func (t *TaskQueue) Shutdown() {
-	t.queue.ShutDown()
+	t.shutdownQueue()
 	<-t.workerDone
 }
 
+func (t *TaskQueue) shutdownQueue() {
+	t.shutdown.Do(t.queue.ShutDown)
+}
+
 // NewTaskQueue creates a new task queue with the given sync function.
-// The sync function is called for every element inserted into the queue.
-func NewTaskQueue(syncFn func(string)) *TaskQueue {
+// The sync function is called for every element inserted into the queue, the
+// element is retried while it returns an error, up to maxRetries times before
+// parkFn is called. The name labels the queue metrics.
+func NewTaskQueue(name string, maxRetries int, syncFn func(string) error, parkFn func(string, error)) *TaskQueue {
+	rateLimiter := workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(500*time.Millisecond, 1000*time.Second),
+		// 10 qps, 100 bucket size. This is only for retry speed and its only the overall factor (not per item)
+		&workqueue.BucketRateLimiter{Bucket: ratelimit.NewBucketWithRate(float64(10), int64(100))},
+	)
 	return &TaskQueue{
-		queue:      workqueue.New(),
+		queue:      workqueue.NewNamedRateLimitingQueue(rateLimiter, name),
 		sync:       syncFn,
+		maxRetries: maxRetries,
+		park:       parkFn,
+		parked:     map[string]bool{},
 		workerDone: make(chan struct{}),
 	}
 }
+
+// WithGracePeriod returns a context that is cancelled gracePeriod after parent
+// is done, giving in-flight work a chance to finish on shutdown
+func WithGracePeriod(parent context.Context, gracePeriod time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-parent.Done():
+			select {
+			case <-time.After(gracePeriod):
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// CachesSynced waits until all caches synced or stopCh is closed, and reports
+// whether they synced. Unlike cache.WaitForCacheSync it returns right away
+// when the caches already are.
+func CachesSynced(stopCh <-chan struct{}, cacheSyncs ...cache.InformerSynced) bool {
+	for _, synced := range cacheSyncs {
+		if !synced() {
+			return cache.WaitForCacheSync(stopCh, cacheSyncs...)
+		}
+	}
+	return true
+}
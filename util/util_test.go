@@ -0,0 +1,101 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+)
+
+const waitTimeout = 10 * time.Second
+
+func TestTaskQueueParksAfterMaxRetries(t *testing.T) {
+	syncs := make(chan string, 10)
+	parked := make(chan error, 1)
+	queue := NewTaskQueue("test-park", 2, func(key string) error {
+		syncs <- key
+		return errors.New("failing")
+	}, func(key string, err error) {
+		parked <- err
+	})
+	stopCh := make(chan struct{})
+	defer queue.Shutdown()
+	defer close(stopCh)
+	go queue.Run(1, time.Millisecond, stopCh)
+
+	queue.Enqueue("cluster")
+	select {
+	case err := <-parked:
+		if err == nil || err.Error() != "failing" {
+			t.Fatalf("parked with %v, want the last sync error", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("cluster wasn't parked")
+	}
+	// the first attempt and maxRetries retries
+	if len(syncs) != 3 {
+		t.Fatalf("synced %d times before parking, want 3", len(syncs))
+	}
+	if queue.Retrying("cluster") {
+		t.Fatal("parked cluster is still retrying")
+	}
+}
+
+func TestTaskQueueIgnoresParkedUntilUnparked(t *testing.T) {
+	syncs := make(chan string, 10)
+	parked := make(chan struct{}, 1)
+	queue := NewTaskQueue("test-unpark", 1, func(key string) error {
+		syncs <- key
+		return errors.New("failing")
+	}, func(string, error) {
+		parked <- struct{}{}
+	})
+	stopCh := make(chan struct{})
+	defer queue.Shutdown()
+	defer close(stopCh)
+	go queue.Run(1, time.Millisecond, stopCh)
+
+	queue.Enqueue("cluster")
+	select {
+	case <-parked:
+	case <-time.After(waitTimeout):
+		t.Fatal("cluster wasn't parked")
+	}
+	for len(syncs) > 0 {
+		<-syncs
+	}
+
+	queue.Enqueue("cluster")
+	queue.EnqueueAfter("cluster", time.Millisecond)
+	select {
+	case <-syncs:
+		t.Fatal("parked cluster was synced")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	queue.Unpark("cluster")
+	queue.Enqueue("cluster")
+	select {
+	case key := <-syncs:
+		if key != "cluster" {
+			t.Fatalf("synced %s, want cluster", key)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("unparked cluster wasn't synced")
+	}
+}
+
+func TestTaskQueueUnparksDeletedObjects(t *testing.T) {
+	queue := NewTaskQueue("test-delete", 1, func(string) error { return nil }, nil)
+	queue.parked["ns/cluster"] = true
+
+	queue.Unpark(cache.DeletedFinalStateUnknown{Key: "ns/cluster"})
+	if queue.isParked("ns/cluster") {
+		t.Fatal("deleted cluster is still parked")
+	}
+	queue.Enqueue("ns/cluster")
+	if n := queue.queue.Len(); n != 1 {
+		t.Fatalf("queue length %d after enqueueing the key again, want 1", n)
+	}
+}